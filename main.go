@@ -3,23 +3,41 @@ package gpabun
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/lemmego/gpa"
-	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/feature"
 	"github.com/uptrace/bun/dialect/mysqldialect"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/dialect/sqlitedialect"
 	"github.com/uptrace/bun/extra/bundebug"
+	"github.com/uptrace/bun/migrate"
+	"github.com/uptrace/bun/schema"
 )
 
 // =====================================
@@ -30,11 +48,91 @@ import (
 type Provider struct {
 	db     *bun.DB
 	config gpa.Config
+
+	closeOnce sync.Once
+	closeErr  error
+	closed    atomic.Bool
+
+	// schema qualifies the tables of repositories when set
+	schema    string
+	qualified sync.Map
+	// tableName names the tables of untagged models from their snake_case
+	// type name in place of Bun's pluralization when set
+	tableName func(string) string
+
+	// redaction of values in logged and reported SQL
+	redactArgs    bool
+	redactColumns map[string]bool
+
+	classifiersMu sync.RWMutex
+	classifiers   []ErrorClassifier
+
+	// cache holds query results of repositories with caching enabled
+	cache Cache
+
+	// defaultScopes holds the named conditions applied to reads per model type
+	defaultScopesMu sync.RWMutex
+	defaultScopes   map[reflect.Type][]defaultScope
+
+	// uuidGenerator fills empty UUID primary keys on insert; UUIDv4 when nil
+	uuidGenerator UUIDGenerator
+
+	// orderByPrimaryKey orders FindAll results by primary key when neither
+	// the query nor the repository sets an order
+	orderByPrimaryKey bool
+
+	// ready caches a failed Ready check for a backoff that grows with
+	// consecutive failures
+	readyMu    sync.Mutex
+	readyErr   error
+	readyUntil time.Time
+	readyDelay time.Duration
+
+	// readOnly refuses writes before they reach the database
+	readOnly bool
+
+	// batchLimits overrides the dialect's limits on batch statements
+	batchLimits BatchLimits
+
+	// queryTimeout bounds each repository operation unless it sets its own
+	// with WithTimeout
+	queryTimeout time.Duration
+
+	// debug records the last query of each repository for LastSQL
+	debug bool
+
+	// sessionVariables derives the PostgreSQL settings applied around each
+	// repository operation from its context
+	sessionVariables SessionVariablesFunc
+
+	// tenantSchema derives the schema set as search_path around each
+	// repository operation from its context
+	tenantSchema TenantSchemaFunc
+}
+
+// NewProviderContext creates a new Bun provider and verifies the connection
+// with a ping bounded by ctx, so startup fails fast when the database can't
+// be reached before ctx's deadline. The provider is closed on failure.
+func NewProviderContext(ctx context.Context, config gpa.Config) (*Provider, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	provider, err := NewProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.db.PingContext(ctx); err != nil {
+		provider.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return provider, nil
 }
 
-// NewProvider creates a new Bun provider instance
+// NewProvider creates a new Bun provider instance. Connections are opened
+// lazily; use NewProviderContext to verify the connection up front.
 func NewProvider(config gpa.Config) (*Provider, error) {
-	provider := &Provider{config: config}
+	provider := &Provider{config: config, readOnly: readOnly(config)}
 
 	// Initialize database connection
 	var sqlDB *sql.DB
@@ -69,25 +167,94 @@ func NewProvider(config gpa.Config) (*Provider, error) {
 		sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
 	}
 
+	// Each connection to :memory: opens a separate empty database, so pin
+	// the pool to a single connection that is never closed
+	if isSQLiteMemory(config) {
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+	}
+
+	// Skip result columns the models don't declare instead of failing the
+	// scan, e.g. while a migration runs ahead of a deploy
+	var dbOpts []bun.DBOption
+	if bunOpts, ok := config.Options["bun"].(map[string]interface{}); ok {
+		if discard, _ := bunOpts["discard_unknown_columns"].(bool); discard {
+			dbOpts = append(dbOpts, bun.WithDiscardUnknownColumns())
+		}
+	}
+
 	// Create Bun database instance
 	var bunDB *bun.DB
 	switch strings.ToLower(config.Driver) {
 	case "postgres", "postgresql":
-		bunDB = bun.NewDB(sqlDB, pgdialect.New())
+		bunDB = bun.NewDB(sqlDB, pgdialect.New(), dbOpts...)
 	case "mysql":
-		bunDB = bun.NewDB(sqlDB, mysqldialect.New())
+		bunDB = bun.NewDB(sqlDB, mysqldialect.New(), dbOpts...)
 	case "sqlite", "sqlite3":
-		bunDB = bun.NewDB(sqlDB, sqlitedialect.New())
+		bunDB = bun.NewDB(sqlDB, sqlitedialect.New(), dbOpts...)
 	}
 
 	// Configure Bun options
 	if options, ok := config.Options["bun"]; ok {
 		if bunOpts, ok := options.(map[string]interface{}); ok {
+			// Redact argument values from logged and reported queries if requested
+			provider.redactArgs, _ = bunOpts["redact_args"].(bool)
+			if columns := stringSlice(bunOpts["redact_columns"]); len(columns) > 0 {
+				provider.redactColumns = make(map[string]bool, len(columns))
+				for _, column := range columns {
+					provider.redactColumns[strings.ToLower(column)] = true
+				}
+			}
+
 			// Add query hook for logging if enabled
 			if logLevel, ok := bunOpts["log_level"].(string); ok && logLevel != "silent" {
-				bunDB.AddQueryHook(bundebug.NewQueryHook(
-					bundebug.WithVerbose(logLevel == "debug"),
-				))
+				hookOpts := []bundebug.Option{bundebug.WithVerbose(logLevel == "debug")}
+				// Send the log to a writer or logger instead of stderr
+				if w := logWriter(bunOpts["log_writer"]); w != nil {
+					hookOpts = append(hookOpts, bundebug.WithWriter(w))
+				}
+				var hook bun.QueryHook = bundebug.NewQueryHook(hookOpts...)
+				if provider.redactArgs || len(provider.redactColumns) > 0 {
+					hook = &redactingQueryHook{hook: hook, provider: provider}
+				}
+
+				bunDB.AddQueryHook(hook)
+			}
+
+			// Qualify table names with a default schema
+			if schemaName, ok := bunOpts["schema"].(string); ok {
+				provider.schema = schemaName
+			}
+
+			// Name tables of untagged models without pluralizing, or with
+			// a custom inflector
+			switch inflect := bunOpts["table_names"].(type) {
+			case func(string) string:
+				provider.tableName = inflect
+			case string:
+				if inflect == "singular" {
+					provider.tableName = func(name string) string { return name }
+				}
+			}
+
+			// Order FindAll results deterministically by default
+			provider.orderByPrimaryKey, _ = bunOpts["order_by_primary_key"].(bool)
+
+			// Override the dialect's limits on batch statements
+			provider.batchLimits = BatchLimits{
+				MaxParams: intOption(bunOpts["max_bind_params"]),
+				BatchSize: intOption(bunOpts["batch_size"]),
+				MaxPacket: intOption(bunOpts["max_packet"]),
+			}
+
+			// Bound repository operations by a default timeout
+			provider.queryTimeout = durationOption(bunOpts["query_timeout"])
+
+			// Record the last query of each repository
+			if provider.debug, _ = bunOpts["debug"].(bool); provider.debug {
+				bunDB.AddQueryHook(lastQueryHook{provider: provider})
 			}
 		}
 	}
@@ -104,6 +271,10 @@ func (p *Provider) Configure(config gpa.Config) error {
 
 // Health checks the database connection health
 func (p *Provider) Health() error {
+	if p.closed.Load() {
+		return errProviderClosed(nil)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -111,22 +282,91 @@ func (p *Provider) Health() error {
 	return sqlDB.PingContext(ctx)
 }
 
-// Close closes the database connection
+const (
+	readyTimeout    = time.Second
+	readyMinBackoff = 100 * time.Millisecond
+	readyMaxBackoff = 5 * time.Second
+)
+
+// Ready reports whether the database is reachable, for readiness probes. It
+// only pings, within a second unless ctx ends sooner. After a failure the
+// same error is returned without pinging for a backoff that doubles with each
+// consecutive failure, up to 5 seconds, so frequent probes of a database that
+// is down stay cheap. Use Health for a liveness check.
+func (p *Provider) Ready(ctx context.Context) error {
+	if p.closed.Load() {
+		return errProviderClosed(nil)
+	}
+
+	p.readyMu.Lock()
+	if p.readyErr != nil && time.Now().Before(p.readyUntil) {
+		err := p.readyErr
+		p.readyMu.Unlock()
+		return err
+	}
+	p.readyMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	err := p.db.DB.PingContext(ctx)
+
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+	if err == nil {
+		p.readyErr, p.readyDelay = nil, 0
+		return nil
+	}
+	p.readyDelay = min(max(2*p.readyDelay, readyMinBackoff), readyMaxBackoff)
+	p.readyErr = err
+	p.readyUntil = time.Now().Add(p.readyDelay)
+	return err
+}
+
+// Close closes the database connection.
+// It is safe to call more than once; later calls return the first call's result.
 func (p *Provider) Close() error {
-	return p.db.Close()
+	p.closeOnce.Do(func() {
+		p.closed.Store(true)
+		p.closeErr = p.db.Close()
+	})
+	return p.closeErr
 }
 
-// SupportedFeatures returns the list of supported features
+// FeatureRowLocking reports support for locking reads such as ForUpdate and
+// ForShare. SQLite ignores plain locking reads, locking the whole database
+// on write instead.
+const FeatureRowLocking gpa.Feature = "row_locking"
+
+// SupportedFeatures returns the features supported by the connected dialect.
+// Full-text search and row locking are left out on SQLite, where the former
+// needs FTS virtual tables; Listen and Notify make PostgreSQL report pub/sub.
 func (p *Provider) SupportedFeatures() []gpa.Feature {
-	return []gpa.Feature{
+	features := []gpa.Feature{
 		gpa.FeatureTransactions,
 		gpa.FeatureJSONQueries,
 		gpa.FeatureIndexing,
 		gpa.FeatureAggregation,
-		gpa.FeatureFullTextSearch,
 		gpa.FeatureSubQueries,
 		gpa.FeatureJoins,
 	}
+
+	switch p.db.Dialect().Name() {
+	case dialect.PG:
+		features = append(features, gpa.FeatureFullTextSearch, FeatureRowLocking, gpa.FeaturePubSub)
+	case dialect.MySQL:
+		features = append(features, gpa.FeatureFullTextSearch, FeatureRowLocking)
+	}
+	return features
+}
+
+// Supports reports whether the connected dialect supports feature
+func (p *Provider) Supports(feature gpa.Feature) bool {
+	for _, supported := range p.SupportedFeatures() {
+		if supported == feature {
+			return true
+		}
+	}
+	return false
 }
 
 // ProviderInfo returns information about this provider
@@ -142,10 +382,192 @@ func (p *Provider) ProviderInfo() gpa.ProviderInfo {
 // GetRepository returns a type-safe repository for any entity type T
 // This enables the unified provider API: userRepo := gpabun.GetRepository[User](provider)
 func GetRepository[T any](p *Provider) gpa.Repository[T] {
+	p.qualifyTable(reflect.TypeOf((*T)(nil)).Elem())
 	return &Repository[T]{
-		db:       p.db,
-		provider: p,
+		db:        p.db,
+		provider:  p,
+		lastQuery: p.newLastQuery(),
+	}
+}
+
+// defaultScope is a named condition applied to every read of a model
+type defaultScope struct {
+	name      string
+	condition gpa.Condition
+}
+
+// RegisterDefaultScope adds a named condition to every read of T through
+// the provider's repositories, e.g. "not_deleted" with deleted_at IS NULL.
+// Queries bypass it with Unscoped(name), or all default scopes with
+// Unscoped(). FindByID always applies the default scopes; updates and
+// deletes are not restricted by them. Registering a name again replaces
+// its condition.
+func RegisterDefaultScope[T any](p *Provider, name string, condition gpa.Condition) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+
+	p.defaultScopesMu.Lock()
+	defer p.defaultScopesMu.Unlock()
+
+	if p.defaultScopes == nil {
+		p.defaultScopes = make(map[reflect.Type][]defaultScope)
+	}
+	// Copy so readers holding the previous slice are unaffected
+	scopes := append([]defaultScope(nil), p.defaultScopes[typ]...)
+	for i, scope := range scopes {
+		if scope.name == name {
+			scopes[i].condition = condition
+			p.defaultScopes[typ] = scopes
+			return
+		}
+	}
+	p.defaultScopes[typ] = append(scopes, defaultScope{name: name, condition: condition})
+}
+
+// RegisterSoftDelete makes column T's soft-delete column, as tagging its
+// field `bun:",soft_delete"` would, for models whose struct can't carry the
+// tag or whose legacy column isn't deleted_at. Deletes then set column to
+// the current time and queries exclude rows where it is set. The field must
+// be a time.Time, *time.Time, sql.NullTime, int64, *int64 or sql.NullInt64;
+// pointer and sql.Null types keep undeleted rows NULL. Register before
+// querying T, since the setting applies to every query of T through p.
+func RegisterSoftDelete[T any](p *Provider, column string) error {
+	table := p.db.Dialect().Tables().Get(reflect.TypeOf((*T)(nil)).Elem())
+	field := table.LookupField(column)
+	if field == nil {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("%s has no column %s", table.Name, column),
+		}
+	}
+
+	var update func(fv reflect.Value, tm time.Time) error
+	switch field.StructField.Type {
+	case reflect.TypeOf(time.Time{}):
+		update = func(fv reflect.Value, tm time.Time) error {
+			fv.Set(reflect.ValueOf(tm))
+			return nil
+		}
+	case reflect.TypeOf((*time.Time)(nil)):
+		update = func(fv reflect.Value, tm time.Time) error {
+			fv.Set(reflect.ValueOf(&tm))
+			return nil
+		}
+	case reflect.TypeOf(sql.NullTime{}):
+		update = func(fv reflect.Value, tm time.Time) error {
+			fv.Set(reflect.ValueOf(sql.NullTime{Time: tm, Valid: true}))
+			return nil
+		}
+	case reflect.TypeOf(int64(0)):
+		update = func(fv reflect.Value, tm time.Time) error {
+			fv.SetInt(tm.UnixNano())
+			return nil
+		}
+	case reflect.TypeOf((*int64)(nil)):
+		update = func(fv reflect.Value, tm time.Time) error {
+			nanos := tm.UnixNano()
+			fv.Set(reflect.ValueOf(&nanos))
+			return nil
+		}
+	case reflect.TypeOf(sql.NullInt64{}):
+		update = func(fv reflect.Value, tm time.Time) error {
+			fv.Set(reflect.ValueOf(sql.NullInt64{Int64: tm.UnixNano(), Valid: true}))
+			return nil
+		}
+	default:
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("soft-delete column %s.%s must hold a time or int64, not %s", table.Name, column, field.StructField.Type),
+		}
+	}
+
+	// Bun tests pointers and nullzero fields with IS NULL and other fields
+	// against their zero value; an invalid sql.Null value is stored as NULL
+	if field.StructField.Type.Kind() == reflect.Struct && field.StructField.Type != reflect.TypeOf(time.Time{}) {
+		field.NullZero = true
+	}
+	table.SoftDeleteField = field
+	table.UpdateSoftDeleteField = update
+	return nil
+}
+
+// defaultScopesOf returns the default scopes registered for typ
+func (p *Provider) defaultScopesOf(typ reflect.Type) []defaultScope {
+	p.defaultScopesMu.RLock()
+	defer p.defaultScopesMu.RUnlock()
+	return p.defaultScopes[typ]
+}
+
+// GetScopedRepository returns a repository whose every read, update and delete
+// is restricted by the conditions of scopes, e.g. a tenant filter:
+//
+//	orders := gpabun.GetScopedRepository[Order](provider, gpa.Where("tenant_id", gpa.OpEqual, tid))
+//
+// Only the conditions of scopes are used, along with any ContextScope, which
+// derives a condition from the context of each operation. Raw queries are not scoped.
+func GetScopedRepository[T any](p *Provider, scopes ...gpa.QueryOption) gpa.Repository[T] {
+	p.qualifyTable(reflect.TypeOf((*T)(nil)).Elem())
+	repo := &Repository[T]{
+		db:        p.db,
+		provider:  p,
+		scopes:    buildQuery(scopes...).Conditions,
+		lastQuery: p.newLastQuery(),
+	}
+	for _, opt := range scopes {
+		if o, ok := opt.(contextScopeOption); ok {
+			repo.contextScopes = append(repo.contextScopes, o.fn)
+		}
+	}
+	return repo
+}
+
+// qualifyTable names the table of typ with the table_names inflector, unless
+// its model tags a table name, and prefixes it with the configured default
+// schema so queries don't depend on the connection's search_path. Models
+// whose table tag already names a schema are left unqualified.
+func (p *Provider) qualifyTable(typ reflect.Type) {
+	if p.schema == "" && p.tableName == nil {
+		return
+	}
+	if _, loaded := p.qualified.LoadOrStore(typ, true); loaded {
+		return
+	}
+
+	table := p.db.Dialect().Tables().Get(typ)
+	if p.tableName != nil && !hasTableTag(typ) {
+		name := p.tableName(table.ModelName)
+		quoted := schema.Safe(schema.NewFormatter(p.db.Dialect()).AppendIdent(nil, name))
+		if table.SQLNameForSelects == table.SQLName {
+			table.SQLNameForSelects = quoted
+		}
+		table.Name, table.SQLName = name, quoted
+	}
+	if p.schema == "" || table.Schema != p.db.Dialect().DefaultSchema() || strings.Contains(table.Name, ".") {
+		return
+	}
+
+	qualified := schema.Safe(schema.NewFormatter(p.db.Dialect()).AppendIdent(nil, p.schema+"."+table.Name))
+	if table.SQLNameForSelects == table.SQLName {
+		table.SQLNameForSelects = qualified
+	}
+	table.SQLName = qualified
+	table.Schema = p.schema
+}
+
+// hasTableTag reports whether the bun.BaseModel field of the struct typ
+// names its table, as in `bun:"table:users"` or `bun:"users"`
+func hasTableTag(typ reflect.Type) bool {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Type != reflect.TypeOf(bun.BaseModel{}) {
+			continue
+		}
+		for j, part := range strings.Split(field.Tag.Get("bun"), ",") {
+			if strings.HasPrefix(part, "table:") || (j == 0 && part != "" && !strings.Contains(part, ":")) {
+				return true
+			}
+		}
 	}
+	return false
 }
 
 // =====================================
@@ -157,8 +579,12 @@ func (p *Provider) DB() interface{} {
 	return p.db.DB
 }
 
-// BeginTx starts a transaction with specific isolation level
+// BeginTx starts a transaction with specific isolation level. A read-only
+// provider only begins read-only transactions.
 func (p *Provider) BeginTx(ctx context.Context, opts *gpa.TxOptions) (interface{}, error) {
+	if p.readOnly && (opts == nil || !opts.ReadOnly) {
+		return nil, p.checkWritable()
+	}
 	if opts == nil {
 		return p.db.BeginTx(ctx, nil)
 	}
@@ -193,16 +619,19 @@ func (p *Provider) Migrate(models ...interface{}) error {
 
 // RawQuery executes raw SQL and returns results
 func (p *Provider) RawQuery(ctx context.Context, query string, args ...interface{}) (interface{}, error) {
+	if p.closed.Load() {
+		return nil, errProviderClosed(nil)
+	}
 	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, p.convertError(err)
 	}
 	defer rows.Close()
 	
 	// Convert rows to map slice
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, err
+		return nil, p.convertError(err)
 	}
 	
 	var results []map[string]interface{}
@@ -214,7 +643,7 @@ func (p *Provider) RawQuery(ctx context.Context, query string, args ...interface
 		}
 		
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
+			return nil, p.convertError(err)
 		}
 		
 		row := make(map[string]interface{})
@@ -224,27 +653,85 @@ func (p *Provider) RawQuery(ctx context.Context, query string, args ...interface
 		results = append(results, row)
 	}
 	
-	return results, rows.Err()
+	return results, p.convertError(rows.Err())
 }
 
 // RawExec executes raw SQL without returning results
 func (p *Provider) RawExec(ctx context.Context, query string, args ...interface{}) (gpa.Result, error) {
+	if err := p.checkWritable(); err != nil {
+		return nil, err
+	}
+	if p.closed.Load() {
+		return nil, errProviderClosed(nil)
+	}
 	result, err := p.db.ExecContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, p.convertError(err)
 	}
-	return &Result{result: result}, nil
+	return &Result{result: result, dialect: p.db.Dialect().Name()}, nil
 }
 
+// CallFunction calls the stored function or procedure name with args and
+// scans its result into dest, which may be a scalar, a struct or a slice of
+// either. PostgreSQL selects from the function, so set-returning functions
+// work too; MySQL uses CALL and SQLite selects the function's value.
+func (p *Provider) CallFunction(ctx context.Context, name string, args []interface{}, dest interface{}) error {
+	call := string(schema.NewFormatter(p.db.Dialect()).AppendIdent(nil, name)) +
+		"(" + strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ") + ")"
+
+	var query string
+	switch p.db.Dialect().Name() {
+	case dialect.PG:
+		query = "SELECT * FROM " + call
+	case dialect.MySQL:
+		query = "CALL " + call
+	default:
+		query = "SELECT " + call
+	}
+
+	if err := p.db.NewRaw(query, args...).Scan(ctx, dest); err != nil {
+		return p.convertError(err)
+	}
+	return nil
+}
 
 // Repository implements gpa.Repository[T] using Bun
 type Repository[T any] struct {
 	db       bun.IDB
 	provider *Provider
+
+	// scopes are ANDed into every statement that reads or modifies rows;
+	// contextScopes are evaluated per statement against its context
+	scopes        []gpa.Condition
+	contextScopes []ContextScopeFunc
+
+	// cacheTTL enables caching of FindByID and FindAll results when positive
+	cacheTTL time.Duration
+
+	// nonAtomic commits each statement of a multi-statement operation on
+	// its own instead of wrapping them in a transaction
+	nonAtomic bool
+
+	// defaultOrder orders FindAll results when the query sets no order
+	defaultOrder []gpa.Order
+
+	// lastQuery records the most recent query in debug mode
+	lastQuery *lastQuery
+
+	// txWrites collects the tables written inside a transaction, whose
+	// cached results are invalidated once it commits
+	txWrites *txWrites
+
+	// prepared is set once the transaction is ended with Prepare, after
+	// which its repositories refuse to run statements
+	prepared *atomic.Bool
 }
 
 // Create inserts a new entity
 func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	if err := r.provider.checkWritable(); err != nil {
+		return err
+	}
 	// Execute before create hook
 	if hook, ok := any(entity).(gpa.BeforeCreateHook); ok {
 		if err := hook.BeforeCreate(ctx); err != nil {
@@ -255,11 +742,19 @@ func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
 			}
 		}
 	}
+	if err := r.generateUUIDs(entity); err != nil {
+		return err
+	}
 	
-	_, err := r.db.NewInsert().Model(entity).Exec(ctx)
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewInsert().Model(entity)
+		_, err := query.Exec(ctx)
+		return r.queryError(err, query)
+	})
 	if err != nil {
-		return convertBunError(err)
+		return err
 	}
+	r.invalidateCache(ctx)
 	
 	// Execute after create hook
 	if hook, ok := any(entity).(gpa.AfterCreateHook); ok {
@@ -272,8 +767,15 @@ func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
 	return nil
 }
 
-// CreateBatch inserts multiple entities
+// CreateBatch inserts multiple entities. Batches larger than BatchSize rows
+// are inserted in chunks, which keeps the SQL of each statement within the
+// server's limits on statement length, such as MySQL's max_allowed_packet.
+// The chunks run in one transaction, so either all entities are inserted or
+// none are; see NonAtomic.
 func (r *Repository[T]) CreateBatch(ctx context.Context, entities []*T) error {
+	if err := r.provider.checkWritable(); err != nil {
+		return err
+	}
 	if len(entities) == 0 {
 		return nil
 	}
@@ -290,10 +792,35 @@ func (r *Repository[T]) CreateBatch(ctx context.Context, entities []*T) error {
 			}
 		}
 	}
+	if err := r.generateUUIDs(entities...); err != nil {
+		return err
+	}
 	
-	_, err := r.db.NewInsert().Model(&entities).Exec(ctx)
+	// Large batches are split into chunks of bounded SQL length; the chunks
+	// are inserted in one transaction
+	size := r.BatchSize()
+	written := false
+	err := r.atomic(ctx, len(entities) > size, func(ctx context.Context, db bun.IDB) error {
+		for start := 0; start < len(entities); start += size {
+			end := min(start+size, len(entities))
+			chunk := entities[start:end]
+			query := db.NewInsert().Model(&chunk)
+			if _, err := query.Exec(ctx); err != nil {
+				// Scanning the returned ids may have truncated the model
+				// slice; restore it so the failed query can be rendered
+				chunk = entities[start:end]
+				return r.queryError(err, query)
+			}
+			written = true
+		}
+		return nil
+	})
+	// A failed batch rolled back, unless NonAtomic kept its earlier chunks
+	if err == nil || (written && r.nonAtomic) {
+		r.invalidateCache(ctx)
+	}
 	if err != nil {
-		return convertBunError(err)
+		return err
 	}
 	
 	// Execute after create hooks for all entities
@@ -309,308 +836,5315 @@ func (r *Repository[T]) CreateBatch(ctx context.Context, entities []*T) error {
 	return nil
 }
 
-// FindByID retrieves a single entity by ID
-func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
-	var entity T
-	err := r.db.NewSelect().Model(&entity).Where("id = ?", id).Scan(ctx)
-	if err != nil {
-		return nil, convertBunError(err)
+// FastInsert inserts entities with multi-row INSERTs it renders itself: the
+// columns are resolved once for T and each row only appends its values,
+// without the per-statement model setup and RETURNING scan of CreateBatch.
+// It suits large loads of new rows. Auto-increment keys are left to the
+// database and not read back, and hooks don't run. Zero values of nullzero
+// or defaulted fields are sent as DEFAULT, or on SQLite as the column's
+// default expression or NULL. Chunks follow BatchSize and are inserted in
+// one transaction unless the repository is NonAtomic. It returns the number
+// of rows inserted.
+func (r *Repository[T]) FastInsert(ctx context.Context, entities []*T) (int64, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return 0, err
 	}
-	
-	// Execute after find hook
-	if hook, ok := any(&entity).(gpa.AfterFindHook); ok {
-		if err := hook.AfterFind(ctx); err != nil {
-			// Log error but don't fail the operation
-			// log.Printf("after find hook failed: %v", err)
+	if len(entities) == 0 {
+		return 0, nil
+	}
+	if err := r.generateUUIDs(entities...); err != nil {
+		return 0, err
+	}
+
+	table := r.table()
+	fields := make([]*schema.Field, 0, len(table.Fields))
+	for _, field := range table.Fields {
+		if field.AutoIncrement || field.Identity {
+			continue
 		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return 0, r.provider.convertError(unsupportedError(fmt.Sprintf("%s has no columns to insert besides generated keys", table.Name)))
 	}
-	
-	return &entity, nil
-}
 
-// FindAll retrieves all entities
-func (r *Repository[T]) FindAll(ctx context.Context, opts ...gpa.QueryOption) ([]*T, error) {
-	var entities []*T
-	query := r.db.NewSelect().Model(&entities)
-	err := query.Scan(ctx)
-	if err != nil {
-		return nil, convertBunError(err)
+	fmter := schema.NewFormatter(r.db.Dialect())
+	defaultPlaceholder := r.db.Dialect().Features().Has(feature.DefaultPlaceholder)
+	header := append([]byte("INSERT INTO "), table.SQLName...)
+	header = append(header, " ("...)
+	for i, field := range fields {
+		if i > 0 {
+			header = append(header, ", "...)
+		}
+		header = append(header, field.SQLName...)
 	}
-	return entities, nil
-}
+	header = append(header, ") VALUES "...)
 
-// Update modifies an existing entity
-func (r *Repository[T]) Update(ctx context.Context, entity *T) error {
-	// Execute before update hook
-	if hook, ok := any(entity).(gpa.BeforeUpdateHook); ok {
-		if err := hook.BeforeUpdate(ctx); err != nil {
-			return gpa.GPAError{
-				Type:    gpa.ErrorTypeValidation,
-				Message: "before update hook failed",
-				Cause:   err,
+	var inserted int64
+	size := r.BatchSize()
+	err := r.atomic(ctx, len(entities) > size, func(ctx context.Context, db bun.IDB) error {
+		query := make([]byte, 0, len(header)+size*16*len(fields))
+		for start := 0; start < len(entities); start += size {
+			query = append(query[:0], header...)
+			for i, entity := range entities[start:min(start+size, len(entities))] {
+				if i > 0 {
+					query = append(query, ", "...)
+				}
+				strct := reflect.ValueOf(entity).Elem()
+				query = append(query, '(')
+				for j, field := range fields {
+					if j > 0 {
+						query = append(query, ", "...)
+					}
+					switch {
+					case !insertsDefault(field, strct):
+						query = field.AppendValue(fmter, query, strct)
+					case defaultPlaceholder:
+						query = append(query, "DEFAULT"...)
+					case field.SQLDefault != "":
+						query = append(query, field.SQLDefault...)
+					default:
+						query = append(query, "NULL"...)
+					}
+				}
+				query = append(query, ')')
+			}
+
+			raw := db.NewRaw(string(query))
+			res, err := raw.Exec(ctx)
+			if err != nil {
+				return r.queryError(err, raw)
 			}
+			n, _ := res.RowsAffected()
+			inserted += n
 		}
+		return nil
+	})
+	// A failed batch rolled back, unless NonAtomic kept its earlier chunks
+	if err == nil || (inserted > 0 && r.nonAtomic) {
+		r.invalidateCache(ctx)
 	}
-	
-	_, err := r.db.NewUpdate().Model(entity).WherePK().Exec(ctx)
 	if err != nil {
-		return convertBunError(err)
-	}
-	
-	// Execute after update hook
-	if hook, ok := any(entity).(gpa.AfterUpdateHook); ok {
-		if err := hook.AfterUpdate(ctx); err != nil {
-			// Log error but don't fail the operation
-			// log.Printf("after update hook failed: %v", err)
-		}
+		return 0, err
 	}
-	
-	return nil
+	return inserted, nil
 }
 
-// UpdatePartial modifies specific fields of an entity
-func (r *Repository[T]) UpdatePartial(ctx context.Context, id interface{}, updates map[string]interface{}) error {
-	var entity T
-	query := r.db.NewUpdate().Model(&entity).Where("id = ?", id)
-	for field, value := range updates {
-		query = query.Set("? = ?", bun.Ident(field), value)
+// insertsDefault reports whether an insert leaves field of strct to the
+// column's default, as Bun does for nil pointers and for zero values of
+// nullzero or defaulted fields
+func insertsDefault(field *schema.Field, strct reflect.Value) bool {
+	if field.IsPtr && field.HasNilValue(strct) {
+		return true
 	}
-	_, err := query.Exec(ctx)
-	return convertBunError(err)
+	return field.HasZeroValue(strct) && (field.NullZero || field.SQLDefault != "")
 }
 
-// Delete removes an entity by ID
-func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
-	var entity T
-	
-	// First, fetch the entity to run hooks on it
-	err := r.db.NewSelect().Model(&entity).Where("id = ?", id).Scan(ctx)
-	if err != nil {
-		return convertBunError(err)
+// CreateIgnore inserts entity unless it conflicts with an existing row on
+// conflictColumns, reporting whether a row was inserted. It uses
+// ON CONFLICT DO NOTHING on PostgreSQL and SQLite and INSERT IGNORE on
+// MySQL, where conflictColumns is not used. With no conflictColumns any
+// unique conflict is ignored.
+func (r *Repository[T]) CreateIgnore(ctx context.Context, entity *T, conflictColumns []string) (bool, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return false, err
 	}
-	
-	// Execute before delete hook
-	if hook, ok := any(&entity).(gpa.BeforeDeleteHook); ok {
-		if err := hook.BeforeDelete(ctx); err != nil {
-			return gpa.GPAError{
+	// Execute before create hook
+	if hook, ok := any(entity).(gpa.BeforeCreateHook); ok {
+		if err := hook.BeforeCreate(ctx); err != nil {
+			return false, gpa.GPAError{
 				Type:    gpa.ErrorTypeValidation,
-				Message: "before delete hook failed",
+				Message: "before create hook failed",
 				Cause:   err,
 			}
 		}
 	}
-	
-	_, err = r.db.NewDelete().Model(&entity).Where("id = ?", id).Exec(ctx)
+	if err := r.generateUUIDs(entity); err != nil {
+		return false, err
+	}
+
+	var affected int64
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewInsert().Model(entity)
+		switch {
+		case db.Dialect().Name() == dialect.MySQL:
+			query = query.Ignore()
+		case len(conflictColumns) > 0:
+			columns := make([]bun.Ident, len(conflictColumns))
+			for i, column := range conflictColumns {
+				columns[i] = bun.Ident(column)
+			}
+			query = query.On("CONFLICT (?) DO NOTHING", bun.In(columns))
+		default:
+			query = query.On("CONFLICT DO NOTHING")
+		}
+
+		result, err := query.Exec(ctx)
+		if err != nil {
+			return r.queryError(err, query)
+		}
+		affected, err = result.RowsAffected()
+		return r.queryError(err, query)
+	})
 	if err != nil {
-		return convertBunError(err)
+		return false, err
 	}
-	
-	// Execute after delete hook
-	if hook, ok := any(&entity).(gpa.AfterDeleteHook); ok {
-		if err := hook.AfterDelete(ctx); err != nil {
+	if affected == 0 {
+		return false, nil
+	}
+	r.invalidateCache(ctx)
+
+	// Execute after create hook
+	if hook, ok := any(entity).(gpa.AfterCreateHook); ok {
+		if err := hook.AfterCreate(ctx); err != nil {
 			// Log error but don't fail the operation
-			// log.Printf("after delete hook failed: %v", err)
+			// log.Printf("after create hook failed: %v", err)
 		}
 	}
-	
-	return nil
-}
 
-// DeleteByCondition removes entities matching the condition
-func (r *Repository[T]) DeleteByCondition(ctx context.Context, condition gpa.Condition) error {
-	var entity T
-	_, err := r.db.NewDelete().Model(&entity).Where(condition.String(), condition.Value()).Exec(ctx)
-	return convertBunError(err)
-}
-
-// Query retrieves entities based on query options
-func (r *Repository[T]) Query(ctx context.Context, opts ...gpa.QueryOption) ([]*T, error) {
-	return r.FindAll(ctx, opts...)
+	return true, nil
 }
 
-// QueryOne retrieves a single entity based on query options
-func (r *Repository[T]) QueryOne(ctx context.Context, opts ...gpa.QueryOption) (*T, error) {
-	entities, err := r.FindAll(ctx, opts...)
-	if err != nil {
-		return nil, err
+// Upsert inserts entity or, when it conflicts with an existing row on
+// conflictColumns, updates that row's other columns with its values,
+// reporting whether a row was written. UpsertIfNewer or UpsertWhere skip
+// stale updates, which are reported as false. PostgreSQL and SQLite use
+// ON CONFLICT DO UPDATE, conflicting on the primary key when no
+// conflictColumns are given; UpsertConflictWhere or UpsertOnConstraint
+// target a partial unique index or a named constraint instead. MySQL uses
+// ON DUPLICATE KEY UPDATE, where conflictColumns is not used and an update
+// that changes nothing is also reported as false. BeforeCreate hooks run;
+// after hooks don't, as an insert can't be told apart from an update.
+func (r *Repository[T]) Upsert(ctx context.Context, entity *T, conflictColumns []string, opts ...UpsertOption) (bool, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return false, err
 	}
-	if len(entities) == 0 {
-		return nil, gpa.GPAError{
-			Type:    gpa.ErrorTypeNotFound,
-			Message: "entity not found",
+	var options upsertOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	switch name := r.db.Dialect().Name(); {
+	case options.where != "" && name == dialect.MySQL:
+		return false, r.provider.convertError(unsupportedError("UpsertWhere is not supported by mysql; use UpsertIfNewer"))
+	case options.conflictWhere != "" && name == dialect.MySQL:
+		return false, r.provider.convertError(unsupportedError("UpsertConflictWhere is not supported by mysql"))
+	case options.constraint != "" && name != dialect.PG:
+		return false, r.provider.convertError(unsupportedError("UpsertOnConstraint requires postgres"))
+	}
+
+	// Execute before create hook
+	if hook, ok := any(entity).(gpa.BeforeCreateHook); ok {
+		if err := hook.BeforeCreate(ctx); err != nil {
+			return false, gpa.GPAError{
+				Type:    gpa.ErrorTypeValidation,
+				Message: "before create hook failed",
+				Cause:   err,
+			}
 		}
 	}
-	return entities[0], nil
+	if err := r.generateUUIDs(entity); err != nil {
+		return false, err
+	}
+
+	table := r.table()
+	var affected int64
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewInsert().Model(entity)
+		if db.Dialect().Name() == dialect.MySQL {
+			query = query.On("DUPLICATE KEY UPDATE")
+			if options.newer != "" {
+				// Assignments see the values set before them, so the
+				// compared column is assigned last
+				newer := bun.Ident(options.newer)
+				set := func(column bun.Ident) {
+					query = query.Set("? = IF(? IS NULL OR VALUES(?) > ?, VALUES(?), ?)", column, newer, newer, newer, column, column)
+				}
+				for _, field := range table.DataFields {
+					if field.Name != options.newer {
+						set(bun.Ident(field.Name))
+					}
+				}
+				set(newer)
+			}
+		} else {
+			columns := conflictColumns
+			if len(columns) == 0 {
+				for _, pk := range table.PKs {
+					columns = append(columns, pk.Name)
+				}
+			}
+			target := make([]interface{}, len(columns))
+			for i, column := range columns {
+				target[i] = bun.Ident(column)
+			}
+			if len(options.conflictExprs) > 0 {
+				target = make([]interface{}, len(options.conflictExprs))
+				for i, expr := range options.conflictExprs {
+					target[i] = bun.Safe("(" + expr + ")")
+				}
+			}
+			switch {
+			case options.constraint != "":
+				query = query.On("CONFLICT ON CONSTRAINT ? DO UPDATE", bun.Ident(options.constraint))
+			case options.conflictWhere != "":
+				// The predicate selects the partial unique index to infer
+				args := append([]interface{}{bun.In(target)}, options.conflictWhereArgs...)
+				query = query.On("CONFLICT (?) WHERE "+options.conflictWhere+" DO UPDATE", args...)
+			default:
+				query = query.On("CONFLICT (?) DO UPDATE", bun.In(target))
+			}
+			if options.newer != "" {
+				newer := bun.Ident(options.newer)
+				query = query.Where("?TableAlias.? IS NULL OR EXCLUDED.? > ?TableAlias.?", newer, newer, newer)
+			}
+			if options.where != "" {
+				query = query.Where(options.where, options.whereArgs...)
+			}
+		}
+
+		result, err := query.Exec(ctx)
+		if err != nil {
+			return r.queryError(err, query)
+		}
+		affected, err = result.RowsAffected()
+		return r.queryError(err, query)
+	})
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+	r.invalidateCache(ctx)
+	return true, nil
 }
 
-// Count returns the number of entities matching the query options
-func (r *Repository[T]) Count(ctx context.Context, opts ...gpa.QueryOption) (int64, error) {
+// UpsertOption configures the update an Upsert applies on conflict
+type UpsertOption func(*upsertOptions)
+
+type upsertOptions struct {
+	newer     string
+	where     string
+	whereArgs []interface{}
+
+	// conflict target other than the conflict columns' full unique index
+	conflictExprs     []string
+	constraint        string
+	conflictWhere     string
+	conflictWhereArgs []interface{}
+}
+
+// UpsertIfNewer updates the conflicting row only when the incoming value of
+// column, such as updated_at or a version, is greater than the stored one or
+// the stored one is NULL, so out-of-order events don't overwrite newer data
+func UpsertIfNewer(column string) UpsertOption {
+	return func(o *upsertOptions) {
+		o.newer = column
+	}
+}
+
+// UpsertWhere updates the conflicting row only when query holds, where
+// EXCLUDED names the incoming row and ?TableAlias the stored one, e.g.
+// "EXCLUDED.version > ?TableAlias.version". Only PostgreSQL and SQLite
+// support it.
+func UpsertWhere(query string, args ...interface{}) UpsertOption {
+	return func(o *upsertOptions) {
+		o.where = query
+		o.whereArgs = args
+	}
+}
+
+// UpsertConflictWhere targets the partial unique index on the conflict
+// columns whose predicate is implied by predicate, e.g. "deleted_at IS NULL"
+// for UNIQUE (email) WHERE deleted_at IS NULL. Without it such an index
+// can't be used as the conflict target. Only PostgreSQL and SQLite support
+// it.
+func UpsertConflictWhere(predicate string, args ...interface{}) UpsertOption {
+	return func(o *upsertOptions) {
+		o.conflictWhere = predicate
+		o.conflictWhereArgs = args
+	}
+}
+
+// UpsertConflictExpr targets the unique index on exprs instead of the
+// conflict columns, e.g. "lower(email)" for a case-insensitive unique index
+// on emails. The expressions are inserted into the SQL as is, so they must
+// not contain user input; they must match the index's expressions. It
+// combines with UpsertConflictWhere for partial expression indexes. MySQL
+// updates on a conflict with any unique index, expression indexes included,
+// so it ignores the target like it ignores the conflict columns.
+func UpsertConflictExpr(exprs ...string) UpsertOption {
+	return func(o *upsertOptions) {
+		o.conflictExprs = exprs
+	}
+}
+
+// UpsertOnConstraint targets the named unique or exclusion constraint with
+// ON CONFLICT ON CONSTRAINT instead of the conflict columns. Partial unique
+// indexes are not constraints; use UpsertConflictWhere for them. Only
+// PostgreSQL supports it.
+func UpsertOnConstraint(name string) UpsertOption {
+	return func(o *upsertOptions) {
+		o.constraint = name
+	}
+}
+
+// BulkCopy loads entities with the dialect's fastest bulk path, returning the
+// number of rows loaded. PostgreSQL streams them with COPY FROM STDIN; MySQL
+// sends extended multi-row INSERTs, each as large as max_allowed_packet
+// permits. All entities are loaded in one transaction. Auto-increment keys
+// are left to the database and not read back, and hooks don't run. On
+// PostgreSQL a defaulted field must be zero in all entities, to take its
+// column default, or in none. SQLite
+// reports unsupported; use CreateBatch there.
+func (r *Repository[T]) BulkCopy(ctx context.Context, entities []*T) (int64, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return 0, err
+	}
+	var load func(ctx context.Context, tx bun.Tx) error
+	switch r.db.Dialect().Name() {
+	case dialect.PG:
+		load = func(ctx context.Context, tx bun.Tx) error {
+			return r.copyIn(ctx, tx, entities)
+		}
+	case dialect.MySQL:
+		load = func(ctx context.Context, tx bun.Tx) error {
+			packet := r.provider.resolveBatchLimits(dialect.MySQL).MaxPacket
+			if packet <= 0 {
+				if err := tx.QueryRowContext(ctx, "SELECT @@max_allowed_packet").Scan(&packet); err != nil {
+					return r.provider.convertError(err)
+				}
+				// Leave room for the packet header
+				packet -= 1024
+			}
+			return r.packInserts(tx, entities, packet, func(query string) error {
+				_, err := tx.ExecContext(ctx, query)
+				return r.provider.convertError(err)
+			})
+		}
+	default:
+		return 0, r.provider.convertError(unsupportedError("bulk copy requires postgres or mysql"))
+	}
+	if len(entities) == 0 {
+		return 0, nil
+	}
+	if err := r.generateUUIDs(entities...); err != nil {
+		return 0, err
+	}
+
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		if tx, ok := db.(bun.Tx); ok {
+			return load(ctx, tx)
+		}
+		return db.RunInTx(ctx, nil, load)
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.invalidateCache(ctx)
+	return int64(len(entities)), nil
+}
+
+// copyIn streams entities into the table with PostgreSQL's COPY
+func (r *Repository[T]) copyIn(ctx context.Context, tx bun.Tx, entities []*T) error {
+	table := r.table()
+	fields, err := copyFields(table, entities)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field.Name
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema(table.Schema, table.Name, columns...))
+	if err != nil {
+		return r.provider.convertError(err)
+	}
+	defer stmt.Close()
+
+	for _, entity := range entities {
+		values, err := copyValues(fields, reflect.ValueOf(entity).Elem())
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return r.provider.convertError(err)
+		}
+	}
+	// Executing without arguments flushes the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return r.provider.convertError(err)
+	}
+	return nil
+}
+
+// packInserts renders entities as multi-row INSERTs of at most limit bytes,
+// unless a single row exceeds it, and passes each to exec. The chunk size
+// starts at BatchSize and halves while a rendered statement is too large.
+func (r *Repository[T]) packInserts(db bun.IDB, entities []*T, limit int, exec func(query string) error) error {
+	fmter := schema.NewFormatter(db.Dialect())
+	size := r.BatchSize()
+	for start := 0; start < len(entities); {
+		chunk := entities[start:min(start+size, len(entities))]
+		query, err := db.NewInsert().Model(&chunk).AppendQuery(fmter, nil)
+		if err != nil {
+			return r.provider.convertError(err)
+		}
+		if len(query) > limit && len(chunk) > 1 {
+			size = len(chunk) / 2
+			continue
+		}
+		if err := exec(string(query)); err != nil {
+			return err
+		}
+		start += len(chunk)
+	}
+	return nil
+}
+
+// copyFields returns the fields of table that COPY loads for entities. COPY
+// has no DEFAULT, so fields left to their column default in every row, such
+// as a zero nullzero CreatedAt with default:current_timestamp, are left
+// out. A defaulted or NOT NULL field left to its default in only some rows
+// can't be loaded and fails with an invalid argument error.
+func copyFields[T any](table *schema.Table, entities []*T) ([]*schema.Field, error) {
+	fields := make([]*schema.Field, 0, len(table.Fields))
+	for _, field := range table.Fields {
+		if field.AutoIncrement || field.Identity {
+			continue
+		}
+		defaults := 0
+		for _, entity := range entities {
+			if insertsDefault(field, reflect.ValueOf(entity).Elem()) {
+				defaults++
+			}
+		}
+		switch {
+		case defaults == 0:
+		case defaults == len(entities):
+			continue
+		case field.SQLDefault != "" || field.NotNull:
+			return nil, gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: fmt.Sprintf("bulk copy can't leave %s to its default in only some rows; set it in all or none", field.Name),
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// copyValues returns the values of fields of strct as COPY accepts them:
+// nullzero zero values as NULL, array fields as PostgreSQL arrays and other
+// composite values without a Valuer as JSON
+func copyValues(fields []*schema.Field, strct reflect.Value) ([]interface{}, error) {
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if field.NullZero && field.HasZeroValue(strct) {
+			continue
+		}
+		v := field.Value(strct)
+		if field.IsPtr && v.IsNil() {
+			continue
+		}
+		value := v.Interface()
+		if _, ok := value.(driver.Valuer); ok {
+			values[i] = value
+			continue
+		}
+		if field.Tag.HasOption("array") {
+			values[i] = pq.Array(value)
+			continue
+		}
+
+		// Times and byte slices pass through; other composites are JSON
+		typ := field.IndirectType
+		switch {
+		case typ == reflect.TypeOf(time.Time{}):
+		case (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) && typ.Elem().Kind() == reflect.Uint8:
+		case typ.Kind() == reflect.Map || typ.Kind() == reflect.Struct || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array:
+			b, err := json.Marshal(value)
+			if err != nil {
+				return nil, gpa.GPAError{
+					Type:    gpa.ErrorTypeSerialization,
+					Message: fmt.Sprintf("failed to encode %s", field.Name),
+					Cause:   err,
+				}
+			}
+			value = string(b)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// FindByID retrieves a single entity by ID.
+// For composite primary keys, id may be a map of column names to values
+// or a T/*T with the key fields set.
+func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	wherePK, err := r.wherePrimaryKey(id)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := r.readScope(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	var entity T
-	count, err := r.db.NewSelect().Model(&entity).Count(ctx)
-	return int64(count), convertBunError(err)
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewSelect().Model(&entity).ApplyQueryBuilder(wherePK).ApplyQueryBuilder(scope)
+		return r.cachedScan(ctx, query, &entity, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	
+	// Execute after find hook
+	if hook, ok := any(&entity).(gpa.AfterFindHook); ok {
+		if err := hook.AfterFind(ctx); err != nil {
+			// Log error but don't fail the operation
+			// log.Printf("after find hook failed: %v", err)
+		}
+	}
+	
+	return &entity, nil
+}
+
+// FindByIDs retrieves the entities with the given primary keys, in no
+// particular order; ids without a row are skipped. Bun inlines the ids as
+// literals, so long id lists are split into several IN queries of at most
+// MaxParams ids to bound the length of each statement's SQL.
+// T must have a single-column primary key.
+func (r *Repository[T]) FindByIDs(ctx context.Context, ids []interface{}) ([]*T, error) {
+	pk, err := r.singlePrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+	scope, err := r.readScope(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make([]*T, 0, len(ids))
+	size := r.maxParams()
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		for start := 0; start < len(ids); start += size {
+			var chunk []*T
+			q := db.NewSelect().Model(&chunk).
+				Where("?TableAlias.? IN (?)", bun.Ident(pk), bun.In(ids[start:min(start+size, len(ids))])).
+				ApplyQueryBuilder(scope)
+			if err := r.cachedScan(ctx, q, &chunk, nil); err != nil {
+				return err
+			}
+			entities = append(entities, chunk...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// FindAll retrieves all entities matching the query options
+func (r *Repository[T]) FindAll(ctx context.Context, opts ...gpa.QueryOption) ([]*T, error) {
+	var entities []*T
+	if err := r.FindAllInto(ctx, &entities, opts...); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// FindAllInto scans the entities matching the query options into dest.
+// dest is truncated first and its backing array and non-nil elements are
+// reused, so a pooled slice avoids allocating on repeated queries.
+func (r *Repository[T]) FindAllInto(ctx context.Context, dest *[]*T, opts ...gpa.QueryOption) error {
+	if dest == nil {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: "destination slice is nil",
+		}
+	}
+
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	opts = r.withDefaultOrder(opts)
+	*dest = (*dest)[:0]
+	return r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		query := newSelect(db, dest, opts...).ApplyQueryBuilder(scope)
+		return r.cachedScan(ctx, query, dest, opts)
+	})
+}
+
+// Stream sends the entities matching the query options over the returned
+// channel as they are read, so downstream stages can process them while the
+// query runs. The entity channel is closed when the rows are exhausted or
+// the query fails; the error channel then yields the error, if any, and is
+// closed. Cancelling ctx stops the query early with ErrorTypeTimeout or
+// the context's error. The query holds a connection until the channel is
+// drained, so don't run other operations on a single-connection pool, such
+// as SQLite :memory:, while streaming. Results are not cached.
+func (r *Repository[T]) Stream(ctx context.Context, opts ...gpa.QueryOption) (<-chan *T, <-chan error) {
+	entities := make(chan *T)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(entities)
+
+		scope, err := r.readScope(ctx, opts)
+		if err != nil {
+			errs <- err
+			return
+		}
+		opts := r.withDefaultOrder(opts)
+		err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+			query := newSelect(db, (*T)(nil), opts...).ApplyQueryBuilder(scope)
+			rows, err := query.Rows(ctx)
+			if err != nil {
+				return r.queryError(err, query)
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				if err := ctx.Err(); err != nil {
+					return r.provider.convertError(err)
+				}
+				entity := new(T)
+				if err := query.DB().ScanRow(ctx, rows, entity); err != nil {
+					return r.queryError(err, query)
+				}
+				select {
+				case entities <- entity:
+				case <-ctx.Done():
+					return r.provider.convertError(ctx.Err())
+				}
+			}
+			return r.queryError(rows.Err(), query)
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return entities, errs
+}
+
+// FindInBatches calls fn with successive batches of up to batchSize
+// entities matching the query options, in primary key order. Each batch is
+// a separate query for the rows after the last key of the previous one
+// (keyset pagination), so the table is neither loaded at once nor held open
+// in a cursor, and rows deleted between batches don't shift later ones.
+// Orders, limits and offsets in opts are ignored. An error from fn stops
+// the iteration and is returned unchanged.
+func (r *Repository[T]) FindInBatches(ctx context.Context, batchSize int, fn func(batch []*T) error, opts ...gpa.QueryOption) error {
+	if batchSize <= 0 {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("batch size must be positive, got %d", batchSize),
+		}
+	}
+	table := r.table()
+	if len(table.PKs) == 0 {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("batches are paged by primary key, %s has none", table.Name),
+		}
+	}
+
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return err
+	}
+	query := buildQuery(opts...)
+	query.Orders, query.Limit, query.Offset = nil, nil, nil
+
+	keys := make([]string, len(table.PKs))
+	keyArgs := make([]interface{}, len(table.PKs))
+	for i, pk := range table.PKs {
+		keys[i] = "?TableAlias.?"
+		keyArgs[i] = bun.Ident(pk.Name)
+	}
+	after := "(" + strings.Join(keys, ", ") + ") > (?)"
+
+	var last []interface{}
+	for {
+		var batch []*T
+		err := r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+			q := applyQuery(db.NewSelect().Model(&batch), query).ApplyQueryBuilder(scope)
+			for _, opt := range opts {
+				if o, ok := opt.(selectOption); ok {
+					if _, ok := o.(orderExprOption); !ok {
+						q = o.applySelect(q)
+					}
+				}
+			}
+			if last != nil {
+				q = q.Where(after, append(keyArgs, bun.In(last))...)
+			}
+			for _, key := range keyArgs {
+				q = q.OrderExpr("?TableAlias.? ASC", key)
+			}
+			q = q.Limit(batchSize)
+			return r.queryError(q.Scan(ctx), q)
+		})
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		strct := reflect.ValueOf(batch[len(batch)-1]).Elem()
+		last = make([]interface{}, len(table.PKs))
+		for i, pk := range table.PKs {
+			last[i] = pk.Value(strct).Interface()
+		}
+	}
+}
+
+// Union returns the distinct entities matched by any of the queries, each
+// given as its own query options. opts apply to the combined result, e.g.
+// to order or limit it.
+func (r *Repository[T]) Union(ctx context.Context, queries [][]gpa.QueryOption, opts ...gpa.QueryOption) ([]*T, error) {
+	return r.union(ctx, "UNION", queries, opts)
+}
+
+// UnionAll is like Union but keeps duplicate rows.
+func (r *Repository[T]) UnionAll(ctx context.Context, queries [][]gpa.QueryOption, opts ...gpa.QueryOption) ([]*T, error) {
+	return r.union(ctx, "UNION ALL", queries, opts)
+}
+
+func (r *Repository[T]) union(ctx context.Context, op string, queries [][]gpa.QueryOption, opts []gpa.QueryOption) ([]*T, error) {
+	if len(queries) == 0 {
+		return nil, gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: "union requires at least one query",
+		}
+	}
+	if err := checkQuery(opts); err != nil {
+		return nil, err
+	}
+
+	var entities []*T
+	err := r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		// Each query is wrapped in a subquery so that its own ORDER BY and
+		// LIMIT are valid on every dialect, SQLite included
+		var expr strings.Builder
+		args := make([]interface{}, 0, len(queries)+1)
+		for i, queryOpts := range queries {
+			scope, err := r.readScope(ctx, queryOpts)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				expr.WriteString(" " + op + " ")
+			}
+			fmt.Fprintf(&expr, "SELECT * FROM (?) AS u%d", i)
+			args = append(args, newSelect(db, (*T)(nil), queryOpts...).ApplyQueryBuilder(scope))
+		}
+
+		q := newSelect(db, &entities, opts...).ModelTableExpr("("+expr.String()+") AS ?TableAlias", args...)
+		all := append([]gpa.QueryOption(nil), opts...)
+		for _, queryOpts := range queries {
+			all = append(all, queryOpts...)
+		}
+		return r.cachedScan(ctx, q, &entities, all)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// QueryMaps retrieves the rows matching the query options as column-to-value maps.
+// Use gpa.Select to limit the columns, e.g. QueryMaps(ctx, gpa.Select("id", "name")).
+func (r *Repository[T]) QueryMaps(ctx context.Context, opts ...gpa.QueryOption) ([]map[string]interface{}, error) {
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]interface{}
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		query := newSelect(db, (*T)(nil), opts...).ApplyQueryBuilder(scope)
+		return r.queryError(query.Scan(ctx, &results), query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// QueryJSON returns the rows matching the query options as a JSON array of
+// objects keyed by column name. PostgreSQL builds the JSON server-side with
+// json_agg; other dialects marshal the rows in Go.
+func (r *Repository[T]) QueryJSON(ctx context.Context, opts ...gpa.QueryOption) (json.RawMessage, error) {
+	if r.db.Dialect().Name() != dialect.PG {
+		rows, err := r.QueryMaps(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if rows == nil {
+			rows = []map[string]interface{}{}
+		}
+		for _, row := range rows {
+			for column, value := range row {
+				// Text columns may scan as bytes, which would marshal as base64
+				if b, ok := value.([]byte); ok {
+					row[column] = string(b)
+				}
+			}
+		}
+		return json.Marshal(rows)
+	}
+
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []byte
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		rows := newSelect(db, (*T)(nil), opts...).ApplyQueryBuilder(scope)
+		query := db.NewSelect().
+			ColumnExpr("coalesce(json_agg(t), '[]'::json)").
+			TableExpr("(?) AS t", rows)
+		return r.queryError(query.Scan(ctx, &result), query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(result), nil
+}
+
+// FindByExample retrieves entities whose columns equal the non-zero fields of example.
+// Zero-value fields are ignored unless their columns are named with IncludeZero.
+func (r *Repository[T]) FindByExample(ctx context.Context, example *T, opts ...gpa.QueryOption) ([]*T, error) {
+	if example == nil {
+		return nil, gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: "example entity is nil",
+		}
+	}
+
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	includeZero := make(map[string]bool)
+	for _, opt := range opts {
+		if o, ok := opt.(includeZeroOption); ok {
+			for _, column := range o.columns {
+				includeZero[column] = true
+			}
+		}
+	}
+
+	strct := reflect.ValueOf(example).Elem()
+	matchExample := func(q bun.QueryBuilder) bun.QueryBuilder {
+		for _, field := range r.table().Fields {
+			if field.HasZeroValue(strct) {
+				if !includeZero[field.Name] {
+					continue
+				}
+				if field.IsPtr {
+					q = q.Where("?TableAlias.? IS NULL", bun.Ident(field.Name))
+					continue
+				}
+			}
+			q = q.Where("?TableAlias.? = ?", bun.Ident(field.Name), field.Value(strct).Interface())
+		}
+		return q
+	}
+
+	var entities []*T
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		query := newSelect(db, &entities, opts...).ApplyQueryBuilder(matchExample).ApplyQueryBuilder(scope)
+		return r.queryError(query.Scan(ctx), query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// Update modifies an existing entity
+func (r *Repository[T]) Update(ctx context.Context, entity *T) error {
+	_, err := r.update(ctx, entity, nil)
+	return err
+}
+
+// UpdateColumns modifies only the named columns of an existing entity,
+// leaving the others, such as created_at or columns not loaded by a
+// projection, unchanged
+func (r *Repository[T]) UpdateColumns(ctx context.Context, entity *T, columns ...string) error {
+	if len(columns) == 0 {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: "no columns to update",
+		}
+	}
+	_, err := r.update(ctx, entity, func(q *bun.UpdateQuery) (*bun.UpdateQuery, error) {
+		return q.Column(columns...), nil
+	})
+	return err
+}
+
+// UpdateExcluding modifies all columns of an existing entity except the named ones
+func (r *Repository[T]) UpdateExcluding(ctx context.Context, entity *T, columns ...string) error {
+	_, err := r.update(ctx, entity, func(q *bun.UpdateQuery) (*bun.UpdateQuery, error) {
+		return q.ExcludeColumn(columns...), nil
+	})
+	return err
+}
+
+// UpdateNonZero modifies only the non-zero fields of an existing entity, so
+// fields left zero, e.g. by a projection, keep their stored values. A zero
+// value can't be told apart from an unset field: to write a legitimately zero
+// value such as false or 0, name its column with IncludeZero, or use
+// UpdateColumns.
+// Fields set by the model's BeforeAppendModel hook are written as well.
+func (r *Repository[T]) UpdateNonZero(ctx context.Context, entity *T, opts ...gpa.QueryOption) error {
+	includeZero := make(map[string]bool)
+	for _, opt := range opts {
+		if o, ok := opt.(includeZeroOption); ok {
+			for _, column := range o.columns {
+				includeZero[column] = true
+			}
+		}
+	}
+
+	_, err := r.update(ctx, entity, func(q *bun.UpdateQuery) (*bun.UpdateQuery, error) {
+		// Bun omits the zero fields as it renders the query, after the
+		// model's BeforeAppendModel hook has set any
+		_, hooked := any(entity).(schema.BeforeAppendModelHook)
+		empty := true
+		strct := reflect.ValueOf(entity).Elem()
+		for _, field := range r.table().DataFields {
+			if includeZero[field.Name] {
+				q = q.Value(field.Name, "?", fieldValue{field: field, strct: strct})
+			}
+			empty = empty && field.HasZeroValue(strct) && !includeZero[field.Name]
+		}
+		if empty && !hooked {
+			return nil, gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: "no non-zero columns to update",
+			}
+		}
+		return q.OmitZero(), nil
+	})
+	return err
+}
+
+// fieldValue renders the value field holds in strct when the query is
+// rendered rather than when it is built
+type fieldValue struct {
+	field *schema.Field
+	strct reflect.Value
+}
+
+func (v fieldValue) AppendQuery(fmter schema.Formatter, b []byte) ([]byte, error) {
+	return v.field.AppendValue(fmter, b, v.strct), nil
+}
+
+// UpdateReturning modifies an existing entity and refreshes it in place with
+// the stored row, including columns computed by the database. It uses
+// RETURNING on PostgreSQL and SQLite and reloads the row on MySQL.
+// It reports ErrorTypeNotFound when no row matches.
+func (r *Repository[T]) UpdateReturning(ctx context.Context, entity *T) error {
+	if r.db.Dialect().Name() == dialect.MySQL {
+		if _, err := r.update(ctx, entity, nil); err != nil {
+			return err
+		}
+		scope, err := r.scope(ctx)
+		if err != nil {
+			return err
+		}
+		return r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+			query := db.NewSelect().Model(entity).WherePK().ApplyQueryBuilder(scope)
+			return r.queryError(query.Scan(ctx), query)
+		})
+	}
+
+	result, err := r.update(ctx, entity, func(q *bun.UpdateQuery) (*bun.UpdateQuery, error) {
+		return q.Returning("*"), nil
+	})
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeNotFound,
+			Message: "record not found",
+			Cause:   sql.ErrNoRows,
+		}
+	}
+	return nil
+}
+
+// update runs the hooks around an update of entity by primary key,
+// with apply, if set, adjusting the query
+func (r *Repository[T]) update(ctx context.Context, entity *T, apply func(*bun.UpdateQuery) (*bun.UpdateQuery, error)) (sql.Result, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return nil, err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute before update hook
+	if hook, ok := any(entity).(gpa.BeforeUpdateHook); ok {
+		if err := hook.BeforeUpdate(ctx); err != nil {
+			return nil, gpa.GPAError{
+				Type:    gpa.ErrorTypeValidation,
+				Message: "before update hook failed",
+				Cause:   err,
+			}
+		}
+	}
+	
+	var result sql.Result
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		query := db.NewUpdate().Model(entity).WherePK().ApplyQueryBuilder(scope)
+		if apply != nil {
+			var err error
+			if query, err = apply(query); err != nil {
+				return err
+			}
+		}
+		var err error
+		result, err = query.Exec(ctx)
+		return r.queryError(err, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.invalidateCache(ctx)
+	
+	// Execute after update hook
+	if hook, ok := any(entity).(gpa.AfterUpdateHook); ok {
+		if err := hook.AfterUpdate(ctx); err != nil {
+			// Log error but don't fail the operation
+			// log.Printf("after update hook failed: %v", err)
+		}
+	}
+	
+	return result, nil
+}
+
+// UpdateBatch updates many entities by primary key, each with its own
+// values, in a few statements instead of one per entity, e.g. to store new
+// positions after a reorder. PostgreSQL and SQLite join the rows as
+// UPDATE ... FROM (VALUES ...); MySQL assigns each column with a CASE over
+// the primary keys. Large batches are split into chunks of BatchSize rows,
+// run in one transaction unless the repository is NonAtomic. Update hooks
+// run for every entity; rows that don't exist are skipped silently.
+func (r *Repository[T]) UpdateBatch(ctx context.Context, entities []*T) error {
+	if err := r.provider.checkWritable(); err != nil {
+		return err
+	}
+	if len(entities) == 0 {
+		return nil
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range entities {
+		if hook, ok := any(entity).(gpa.BeforeUpdateHook); ok {
+			if err := hook.BeforeUpdate(ctx); err != nil {
+				return gpa.GPAError{
+					Type:    gpa.ErrorTypeValidation,
+					Message: "before update hook failed",
+					Cause:   err,
+				}
+			}
+		}
+	}
+
+	size := r.BatchSize()
+	written := false
+	err = r.atomic(ctx, len(entities) > size, func(ctx context.Context, db bun.IDB) error {
+		for start := 0; start < len(entities); start += size {
+			chunk := entities[start:min(start+size, len(entities))]
+			var query *bun.UpdateQuery
+			if db.Dialect().Name() == dialect.MySQL {
+				query = r.updateCases(db, chunk).ApplyQueryBuilder(scope)
+			} else {
+				query = db.NewUpdate().Model(&chunk).Bulk()
+				if len(r.scopes) > 0 || len(r.contextScopes) > 0 {
+					// The scopes' columns would be ambiguous next to those
+					// of the joined rows, so they filter a subquery instead
+					table := r.table()
+					columns := make([]string, len(table.PKs))
+					args := make([]interface{}, 0, len(table.PKs)+1)
+					for i, pk := range table.PKs {
+						columns[i] = "?TableAlias.?"
+						args = append(args, bun.Ident(pk.Name))
+					}
+					scoped := db.NewSelect().Model((*T)(nil)).ColumnExpr(strings.Join(columns, ", "), args...).ApplyQueryBuilder(scope)
+					query = query.Where("("+strings.Join(columns, ", ")+") IN (?)", append(args, scoped)...)
+				}
+			}
+			if _, err := query.Exec(ctx); err != nil {
+				return r.queryError(err, query)
+			}
+			written = true
+		}
+		return nil
+	})
+	// A failed batch rolled back, unless NonAtomic kept its earlier chunks
+	if err == nil || (written && r.nonAtomic) {
+		r.invalidateCache(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range entities {
+		if hook, ok := any(entity).(gpa.AfterUpdateHook); ok {
+			if err := hook.AfterUpdate(ctx); err != nil {
+				// Log error but don't fail the operation
+			}
+		}
+	}
+	return nil
+}
+
+// updateCases builds an UPDATE of chunk that assigns each data column a
+// CASE over the rows' primary keys, for dialects without UPDATE ... FROM
+func (r *Repository[T]) updateCases(db bun.IDB, chunk []*T) *bun.UpdateQuery {
+	table := r.table()
+	fmter := schema.NewFormatter(db.Dialect())
+
+	// Values are rendered as literals, so they are passed as safe arguments
+	// rather than formatted, where a ? in a string would be a placeholder
+	keys := make([]string, len(chunk))
+	for i, entity := range chunk {
+		strct := reflect.ValueOf(entity).Elem()
+		conditions := make([]string, len(table.PKs))
+		for j, pk := range table.PKs {
+			conditions[j] = string(fmter.AppendIdent(nil, pk.Name)) + " = " + string(pk.AppendValue(fmter, nil, strct))
+		}
+		keys[i] = strings.Join(conditions, " AND ")
+	}
+
+	var entity T
+	query := db.NewUpdate().Model(&entity)
+	for _, field := range table.DataFields {
+		expr := []byte("CASE")
+		for i, entity := range chunk {
+			expr = append(expr, " WHEN "+keys[i]+" THEN "...)
+			expr = field.AppendValue(fmter, expr, reflect.ValueOf(entity).Elem())
+		}
+		expr = append(expr, " END"...)
+		query = query.Set("? = ?", bun.Ident(field.Name), bun.Safe(expr))
+	}
+	return query.Where("?", bun.Safe("("+strings.Join(keys, ") OR (")+")"))
+}
+
+// UpdatePartial modifies specific fields of an entity. Fields set by the
+// model's BeforeAppendModel hook on an empty entity are written as well.
+// Zero values of nullzero columns are written as NULL, as inserts and
+// updates of the entity write them; pass Expr("''") to store an actual
+// empty string. It reports ErrorTypeNotFound when no row within the
+// repository scopes has the id. MySQL counts only changed rows, so there an
+// update that changes nothing checks that the row exists.
+func (r *Repository[T]) UpdatePartial(ctx context.Context, id interface{}, updates map[string]interface{}) error {
+	if err := r.provider.checkWritable(); err != nil {
+		return err
+	}
+	wherePK, err := r.wherePrimaryKey(id)
+	if err != nil {
+		return err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	table := r.table()
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		// A nil model keeps Bun from running the BeforeAppendModel hook,
+		// which runs once below on an empty entity
+		query := db.NewUpdate().Model((*T)(nil)).ApplyQueryBuilder(wherePK).ApplyQueryBuilder(scope)
+		for field, value := range updates {
+			switch v := value.(type) {
+			case SQLExpr:
+				query = query.Set("? = "+v.Query, append([]interface{}{bun.Ident(field)}, v.Args...)...)
+			case nullValue:
+				query = query.Set("? = NULL", bun.Ident(field))
+			default:
+				if f, ok := table.FieldMap[field]; ok && f.NullZero {
+					if v := reflect.ValueOf(value); !v.IsValid() || v.IsZero() {
+						query = query.Set("? = NULL", bun.Ident(field))
+						continue
+					}
+				}
+				query = query.Set("? = ?", bun.Ident(field), value)
+			}
+		}
+		// The model's BeforeAppendModel hook, e.g. one stamping updated_at, fills
+		// fields of the otherwise empty entity; write them alongside updates
+		var entity T
+		if hook, ok := any(&entity).(schema.BeforeAppendModelHook); ok {
+			if err := hook.BeforeAppendModel(ctx, query); err != nil {
+				return r.provider.convertError(err)
+			}
+			strct := reflect.ValueOf(&entity).Elem()
+			for _, field := range table.DataFields {
+				if _, ok := updates[field.Name]; !ok && !field.HasZeroValue(strct) {
+					query = query.Set("? = ?", bun.Ident(field.Name), field.Value(strct).Interface())
+				}
+			}
+		}
+		result, err := query.Exec(ctx)
+		if err != nil {
+			return r.queryError(err, query)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil || affected > 0 {
+			return r.queryError(err, query)
+		}
+
+		exists := false
+		if db.Dialect().Name() == dialect.MySQL {
+			q := db.NewSelect().Model((*T)(nil)).ApplyQueryBuilder(wherePK).ApplyQueryBuilder(scope)
+			if exists, err = q.Exists(ctx); err != nil {
+				return r.queryError(err, q)
+			}
+		}
+		if !exists {
+			return gpa.GPAError{
+				Type:    gpa.ErrorTypeNotFound,
+				Message: "record not found",
+				Cause:   sql.ErrNoRows,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidateCache(ctx)
+	return nil
+}
+
+// Delete removes an entity by ID.
+// Accepts the same composite key forms as FindByID.
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	if err := r.provider.checkWritable(); err != nil {
+		return err
+	}
+	wherePK, err := r.wherePrimaryKey(id)
+	if err != nil {
+		return err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	var entity T
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		// First, fetch the entity to run hooks on it
+		selectQuery := db.NewSelect().Model(&entity).ApplyQueryBuilder(wherePK).ApplyQueryBuilder(scope)
+		if err := selectQuery.Scan(ctx); err != nil {
+			return r.queryError(err, selectQuery)
+		}
+
+		// Execute before delete hook
+		if hook, ok := any(&entity).(gpa.BeforeDeleteHook); ok {
+			if err := hook.BeforeDelete(ctx); err != nil {
+				return gpa.GPAError{
+					Type:    gpa.ErrorTypeValidation,
+					Message: "before delete hook failed",
+					Cause:   err,
+				}
+			}
+		}
+
+		deleteQuery := db.NewDelete().Model(&entity).ApplyQueryBuilder(wherePK).ApplyQueryBuilder(scope)
+		_, err := deleteQuery.Exec(ctx)
+		return r.queryError(err, deleteQuery)
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidateCache(ctx)
+	
+	// Execute after delete hook
+	if hook, ok := any(&entity).(gpa.AfterDeleteHook); ok {
+		if err := hook.AfterDelete(ctx); err != nil {
+			// Log error but don't fail the operation
+			// log.Printf("after delete hook failed: %v", err)
+		}
+	}
+	
+	return nil
+}
+
+// DeleteByCondition removes entities matching the condition. A nil or empty
+// condition fails with a validation error instead of deleting every row; use
+// DeleteAll with AllowUnconditional for that. A value that doesn't fit the
+// operator, such as a BETWEEN without two bounds, is an invalid argument.
+func (r *Repository[T]) DeleteByCondition(ctx context.Context, condition gpa.Condition) error {
+	if err := r.provider.checkWritable(); err != nil {
+		return err
+	}
+	if err := requireConditions([]gpa.Condition{condition}, nil); err != nil {
+		return err
+	}
+	if err := checkCondition(condition); err != nil {
+		return err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		var entity T
+		sql, args := conditionSQL(condition)
+		query := db.NewDelete().Model(&entity).Where(sql, args...).ApplyQueryBuilder(scope)
+		_, err := query.Exec(ctx)
+		return r.queryError(err, query)
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidateCache(ctx)
+	return nil
+}
+
+// DeleteReturning removes the entities matching condition within the
+// repository scopes and returns the deleted rows, e.g. to emit events or
+// archive them. It uses RETURNING on PostgreSQL and SQLite; MySQL locks the
+// matching rows with SELECT ... FOR UPDATE and deletes them by primary key
+// in the same transaction. Models with a soft_delete column are soft
+// deleted. Without a condition it fails with a validation error unless
+// AllowUnconditional is passed.
+func (r *Repository[T]) DeleteReturning(ctx context.Context, condition gpa.Condition, opts ...gpa.QueryOption) ([]*T, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := requireConditions([]gpa.Condition{condition}, opts); err != nil {
+		return nil, err
+	}
+	if err := checkCondition(condition); err != nil {
+		return nil, err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	where := func(q bun.QueryBuilder) bun.QueryBuilder {
+		q = q.Where("1 = 1")
+		if !emptyCondition(condition) {
+			sql, args := conditionSQL(condition)
+			q = q.Where(sql, args...)
+		}
+		return scope(q)
+	}
+
+	var entities []*T
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		if db.Dialect().Name() != dialect.MySQL {
+			query := db.NewDelete().Model(&entities).ApplyQueryBuilder(where).Returning("*")
+			_, err := query.Exec(ctx)
+			return r.queryError(err, query)
+		}
+
+		deleteLocked := func(ctx context.Context, tx bun.Tx) error {
+			query := tx.NewSelect().Model(&entities).ApplyQueryBuilder(where).For("UPDATE")
+			if err := query.Scan(ctx); err != nil {
+				return r.queryError(err, query)
+			}
+			if len(entities) == 0 {
+				return nil
+			}
+			deleted := entities
+			del := tx.NewDelete().Model(&deleted).WherePK()
+			_, err := del.Exec(ctx)
+			return r.queryError(err, del)
+		}
+		if tx, ok := db.(bun.Tx); ok {
+			return deleteLocked(ctx, tx)
+		}
+		return db.RunInTx(ctx, nil, deleteLocked)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.invalidateCache(ctx)
+	return entities, nil
+}
+
+// DeleteAll removes all entities matching the conditions of the query
+// options and the repository scopes, returning the number of rows deleted.
+// Models with a soft_delete column are soft deleted. Without conditions it
+// fails with a validation error unless AllowUnconditional is passed.
+func (r *Repository[T]) DeleteAll(ctx context.Context, opts ...gpa.QueryOption) (int64, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return 0, err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	query := buildQuery(opts...)
+	if err := requireConditions(query.Conditions, opts); err != nil {
+		return 0, err
+	}
+	if err := checkQuery(opts); err != nil {
+		return 0, err
+	}
+
+	var result sql.Result
+	err = r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		var entity T
+		q := db.NewDelete().Model(&entity).ApplyQueryBuilder(scope).Where("1 = 1")
+		for _, condition := range query.Conditions {
+			if !emptyCondition(condition) {
+				sql, args := conditionSQL(condition)
+				q = q.Where(sql, args...)
+			}
+		}
+
+		var err error
+		result, err = q.Exec(ctx)
+		return r.queryError(err, q)
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.invalidateCache(ctx)
+	return result.RowsAffected()
+}
+
+// DeleteByIDs removes the entities with the given primary keys within the
+// repository scopes, returning the number of rows deleted. Models with a
+// soft_delete column are soft deleted. Bun inlines the ids as literals, so
+// long id lists are split into several statements of at most MaxParams ids
+// to bound their SQL length, run in one transaction unless the repository
+// is NonAtomic. T must have a single-column primary key.
+func (r *Repository[T]) DeleteByIDs(ctx context.Context, ids []interface{}) (int64, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return 0, err
+	}
+	pk, err := r.singlePrimaryKey()
+	if err != nil {
+		return 0, err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	size := r.maxParams()
+	err = r.atomic(ctx, len(ids) > size, func(ctx context.Context, db bun.IDB) error {
+		for start := 0; start < len(ids); start += size {
+			var entity T
+			q := db.NewDelete().Model(&entity).
+				Where("?TableAlias.? IN (?)", bun.Ident(pk), bun.In(ids[start:min(start+size, len(ids))])).
+				ApplyQueryBuilder(scope)
+			result, err := q.Exec(ctx)
+			if err != nil {
+				return r.queryError(err, q)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return r.queryError(err, q)
+			}
+			deleted += affected
+		}
+		return nil
+	})
+	if deleted > 0 {
+		r.invalidateCache(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Query retrieves entities based on query options
+func (r *Repository[T]) Query(ctx context.Context, opts ...gpa.QueryOption) ([]*T, error) {
+	return r.FindAll(ctx, opts...)
+}
+
+// QueryOne retrieves a single entity based on query options
+func (r *Repository[T]) QueryOne(ctx context.Context, opts ...gpa.QueryOption) (*T, error) {
+	entities, err := r.FindAll(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, gpa.GPAError{
+			Type:    gpa.ErrorTypeNotFound,
+			Message: "entity not found",
+			Cause:   sql.ErrNoRows,
+		}
+	}
+	return entities[0], nil
+}
+
+// Count returns the number of entities matching the query options
+func (r *Repository[T]) Count(ctx context.Context, opts ...gpa.QueryOption) (int64, error) {
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var entity T
+	query := buildQuery(opts...)
+	query.Orders, query.Limit, query.Offset = nil, nil, nil
+
+	var count int
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		var err error
+		q := applyQuery(db.NewSelect().Model(&entity), query).ApplyQueryBuilder(scope)
+		count, err = q.Count(ctx)
+		return r.queryError(err, q)
+	})
+	return int64(count), err
+}
+
+// NullGroup is the CountBy key of rows whose group column is NULL. Its
+// leading NUL byte keeps it apart from any stored text.
+const NullGroup = "\x00NULL"
+
+// CountBy counts the entities matching the query options per distinct value
+// of column, as SELECT column, COUNT(*) ... GROUP BY column would. Values are
+// keyed by their text form; rows where column is NULL are counted under
+// NullGroup.
+func (r *Repository[T]) CountBy(ctx context.Context, column string, opts ...gpa.QueryOption) (map[string]int64, error) {
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	query := buildQuery(opts...)
+	query.Fields, query.Groups = nil, nil
+	query.Orders, query.Limit, query.Offset = nil, nil, nil
+
+	var groups []struct {
+		Key   sql.NullString `bun:"group_key"`
+		Count int64          `bun:"group_count"`
+	}
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		q := applyQuery(db.NewSelect().Model((*T)(nil)), query).
+			ColumnExpr("? AS group_key", bun.Ident(column)).
+			ColumnExpr("COUNT(*) AS group_count").
+			GroupExpr("?", bun.Ident(column)).
+			ApplyQueryBuilder(scope)
+		return r.queryError(q.Scan(ctx, &groups), q)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(groups))
+	for _, group := range groups {
+		key := NullGroup
+		if group.Key.Valid {
+			key = group.Key.String
+		}
+		counts[key] = group.Count
+	}
+	return counts, nil
+}
+
+// TimeBucket is an aggregate over the rows whose time column falls in the
+// interval starting at Bucket. A NULL aggregate is reported as 0.
+type TimeBucket struct {
+	Bucket time.Time
+	Value  float64
+}
+
+// GroupByTime computes aggregate, such as "COUNT(*)" or "SUM(amount)", over
+// the entities matching the query options per interval of the time column:
+// "minute", "hour", "day", "week" (starting on Monday), "month" or "year".
+// Buckets are returned in order, and intervals without rows are omitted.
+// Buckets start in the time zone the column is stored in, UTC unless the
+// database converts it. PostgreSQL truncates with date_trunc, MySQL with
+// DATE_FORMAT and SQLite with strftime. The aggregate is inserted verbatim:
+// never build it from user input.
+func (r *Repository[T]) GroupByTime(ctx context.Context, column, interval, aggregate string, opts ...gpa.QueryOption) ([]TimeBucket, error) {
+	bucket, args, err := timeBucketSQL(r.db.Dialect().Name(), column, interval)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	query := buildQuery(opts...)
+	query.Fields, query.Groups = nil, nil
+	query.Orders, query.Limit, query.Offset = nil, nil, nil
+
+	// database/sql converts integer aggregates such as COUNT(*) to float
+	var rows []struct {
+		Bucket time.Time       `bun:"bucket"`
+		Value  sql.NullFloat64 `bun:"value"`
+	}
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		q := applyQuery(db.NewSelect().Model((*T)(nil)), query).
+			ColumnExpr(bucket+" AS bucket", args...).
+			ColumnExpr(aggregate+" AS value").
+			GroupExpr(bucket, args...).
+			OrderExpr("bucket ASC").
+			ApplyQueryBuilder(scope)
+		return r.queryError(q.Scan(ctx, &rows), q)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]TimeBucket, len(rows))
+	for i, row := range rows {
+		buckets[i] = TimeBucket{Bucket: row.Bucket, Value: row.Value.Float64}
+	}
+	return buckets, nil
+}
+
+// timeBucketSQL renders the start of the interval column falls in for the
+// dialect, as a query fragment and its arguments
+func timeBucketSQL(name dialect.Name, column, interval string) (string, []interface{}, error) {
+	formats := map[string][2]string{
+		// MySQL DATE_FORMAT, SQLite strftime
+		"minute": {"%Y-%m-%d %H:%i:00", "%Y-%m-%d %H:%M:00"},
+		"hour":   {"%Y-%m-%d %H:00:00", "%Y-%m-%d %H:00:00"},
+		"day":    {"%Y-%m-%d", "%Y-%m-%d 00:00:00"},
+		"week":   {"%Y-%m-%d", "%Y-%m-%d 00:00:00"},
+		"month":  {"%Y-%m-01", "%Y-%m-01 00:00:00"},
+		"year":   {"%Y-01-01", "%Y-01-01 00:00:00"},
+	}
+	interval = strings.ToLower(interval)
+	format, ok := formats[interval]
+	if !ok {
+		return "", nil, gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("unknown time interval %q; use minute, hour, day, week, month or year", interval),
+		}
+	}
+
+	col := bun.Ident(column)
+	switch name {
+	case dialect.PG:
+		return "date_trunc(?, ?)", []interface{}{interval, col}, nil
+	case dialect.MySQL:
+		// DATE_FORMAT returns text; the cast lets parseTime scan it
+		if interval == "week" {
+			return "CAST(DATE_SUB(DATE(?), INTERVAL WEEKDAY(?) DAY) AS DATETIME)", []interface{}{col, col}, nil
+		}
+		return "CAST(DATE_FORMAT(?, ?) AS DATETIME)", []interface{}{col, format[0]}, nil
+	default:
+		if interval == "week" {
+			// The next Sunday, or the day itself, less six days
+			return "strftime(?, ?, 'weekday 0', '-6 days')", []interface{}{format[1], col}, nil
+		}
+		return "strftime(?, ?)", []interface{}{format[1], col}, nil
+	}
+}
+
+// Exists checks if any entities match the query options. It runs
+// SELECT EXISTS, which stops at the first matching row instead of counting
+// them all.
+func (r *Repository[T]) Exists(ctx context.Context, opts ...gpa.QueryOption) (bool, error) {
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+
+	query := buildQuery(opts...)
+	query.Orders, query.Limit, query.Offset = nil, nil, nil
+
+	var exists bool
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		var err error
+		q := applyQuery(db.NewSelect().Model((*T)(nil)), query).ApplyQueryBuilder(scope)
+		exists, err = q.Exists(ctx)
+		return r.queryError(err, q)
+	})
+	return exists, err
+}
+
+// ExistsBy checks if any entity has value in field, e.g. whether an email is
+// taken, as SELECT EXISTS(SELECT 1 FROM t WHERE field = ?) would.
+// A nil value matches NULL.
+func (r *Repository[T]) ExistsBy(ctx context.Context, field string, value interface{}) (bool, error) {
+	if value == nil {
+		return r.Exists(ctx, gpa.WhereNull(field))
+	}
+	return r.Exists(ctx, gpa.Where(field, gpa.OpEqual, value))
+}
+
+// Transaction executes a function within a transaction. The provider's
+// session variables and tenant schema for ctx are applied as the
+// transaction begins. On a read-only provider the transaction is read-only
+// and its writes fail.
+func (r *Repository[T]) Transaction(ctx context.Context, fn gpa.TransactionFunc[T]) error {
+	var opts *sql.TxOptions
+	if r.provider != nil && r.provider.readOnly {
+		opts = &sql.TxOptions{ReadOnly: true}
+	}
+
+	variables, err := r.provider.sessionSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.checkPrepared(); err != nil {
+		return err
+	}
+	writes, prepared := r.txWrites, r.prepared
+	if writes == nil {
+		writes = &txWrites{}
+	}
+	if prepared == nil {
+		prepared = &atomic.Bool{}
+	}
+	err = r.db.RunInTx(ctx, opts, func(ctx context.Context, tx bun.Tx) error {
+		if len(variables) > 0 {
+			if err := r.provider.applySessionVariables(ctx, tx, variables, true); err != nil {
+				return err
+			}
+		}
+		repo := *r
+		repo.db = tx
+		repo.cacheTTL = 0
+		repo.txWrites = writes
+		repo.prepared = prepared
+		return fn(&Transaction[T]{Repository: &repo})
+	})
+	// Invalidating before the commit would let a concurrent read cache the
+	// rows it replaces; a nested transaction leaves it to the outermost one
+	if err == nil && r.txWrites == nil {
+		writes.invalidate(ctx, r.provider)
+	}
+	return err
+}
+
+// RawQuery executes a raw query and returns results. Arguments are bound to
+// ? placeholders on every dialect: Bun inlines them as escaped literals
+// before the query reaches the driver, so $1-style placeholders are not
+// needed on PostgreSQL. Escape a literal question mark as \?. The same
+// holds for RawRows, RawExec and the provider's raw methods.
+func (r *Repository[T]) RawQuery(ctx context.Context, query string, args []interface{}) ([]*T, error) {
+	var entities []*T
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		raw := db.NewRaw(query, args...)
+		return r.queryError(raw.Scan(ctx, &entities), raw)
+	})
+	return entities, err
+}
+
+// RawRows executes a raw query and returns its rows unscanned, e.g. to read
+// the several result sets of a stored procedure with NextResultSet. The
+// caller must close the rows. Outside a transaction the rows are read
+// without the provider's session variables.
+func (r *Repository[T]) RawRows(ctx context.Context, query string, args []interface{}) (*sql.Rows, error) {
+	if err := r.checkPrepared(); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, r.queryError(err, r.db.NewRaw(query, args...))
+	}
+	return rows, nil
+}
+
+// RawExec executes a raw command. PostgreSQL reports no LastInsertId for
+// it; use RawExecReturning to read generated ids there.
+func (r *Repository[T]) RawExec(ctx context.Context, query string, args []interface{}) (gpa.Result, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return nil, err
+	}
+	var result sql.Result
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		raw := db.NewRaw(query, args...)
+		var err error
+		result, err = raw.Exec(ctx)
+		return r.queryError(err, raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.invalidateCache(ctx)
+	return &Result{result: result, dialect: r.db.Dialect().Name()}, nil
+}
+
+// RawExecReturning executes a raw command ending in a RETURNING clause,
+// e.g. INSERT ... RETURNING id, and reads the integer first column of each
+// returned row as a generated id. The result's LastInsertId is the last of
+// them, RowsAffected their count and InsertedIDs all of them, in order.
+// PostgreSQL and SQLite support RETURNING; MySQL reports unsupported, use
+// RawExec and LastInsertId there.
+func (r *Repository[T]) RawExecReturning(ctx context.Context, query string, args []interface{}) (gpa.Result, error) {
+	if err := r.provider.checkWritable(); err != nil {
+		return nil, err
+	}
+	if r.db.Dialect().Name() == dialect.MySQL {
+		return nil, r.provider.convertError(unsupportedError("RETURNING is not supported by mysql; use RawExec and LastInsertId"))
+	}
+
+	ids := []int64{}
+	err := r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return r.queryError(err, db.NewRaw(query, args...))
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return r.queryError(err, db.NewRaw(query, args...))
+		}
+		if len(columns) == 0 {
+			return gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: "query returns no columns; add a RETURNING clause",
+			}
+		}
+		dest := make([]interface{}, len(columns))
+		for i := range dest {
+			dest[i] = new(interface{})
+		}
+		for rows.Next() {
+			var id int64
+			dest[0] = &id
+			if err := rows.Scan(dest...); err != nil {
+				return r.queryError(err, db.NewRaw(query, args...))
+			}
+			ids = append(ids, id)
+		}
+		return r.queryError(rows.Err(), db.NewRaw(query, args...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.invalidateCache(ctx)
+	return &Result{dialect: r.db.Dialect().Name(), ids: ids}, nil
+}
+
+// GetEntityInfo returns metadata about the entity
+func (r *Repository[T]) GetEntityInfo() (*gpa.EntityInfo, error) {
+	var entity T
+	entityType := reflect.TypeOf(entity)
+
+	// Bun's table metadata flattens the fields of embedded structs
+	table := r.table()
+	fields := make([]gpa.FieldInfo, 0, len(table.Fields))
+	for _, field := range table.Fields {
+		info := gpa.FieldInfo{
+			Name:            field.Name,
+			Type:            field.StructField.Type,
+			DatabaseType:    field.CreateTableSQLType,
+			Tag:             string(field.StructField.Tag),
+			IsPrimaryKey:    field.IsPK,
+			IsNullable:      !field.NotNull && !field.IsPK,
+			IsAutoIncrement: field.AutoIncrement || field.Identity,
+		}
+		if field.SQLDefault != "" {
+			info.DefaultValue = field.SQLDefault
+		}
+		fields = append(fields, info)
+	}
+	primaryKey := make([]string, len(table.PKs))
+	for i, pk := range table.PKs {
+		primaryKey[i] = pk.Name
+	}
+
+	return &gpa.EntityInfo{
+		Name:       entityType.Name(),
+		TableName:  entityType.Name(),
+		Fields:     fields,
+		PrimaryKey: primaryKey,
+	}, nil
+}
+
+// Close closes the repository
+func (r *Repository[T]) Close() error {
+	return nil
+}
+
+// ExplainQuery renders the SELECT the query options would produce without executing it.
+// Bun interpolates arguments while rendering, so args is always empty. Context scopes
+// are evaluated with context.Background().
+func (r *Repository[T]) ExplainQuery(opts ...gpa.QueryOption) (string, []interface{}, error) {
+	query, err := r.renderSelect(context.Background(), opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	return query, nil, nil
+}
+
+// RenderedQuery is a SELECT rendered from query options, whose SQL can be
+// inspected before it is run, any number of times, with Scan
+type RenderedQuery struct {
+	sql  string
+	err  error
+	scan func(ctx context.Context, sql string, dest interface{}) error
+}
+
+// Build renders the SELECT the query options would produce without executing
+// it, e.g. to assert on the generated SQL in tests or log a planned query.
+// Context scopes are evaluated with context.Background(); a failure to render
+// is reported by Err and Scan.
+func (r *Repository[T]) Build(opts ...gpa.QueryOption) *RenderedQuery {
+	query, err := r.renderSelect(context.Background(), opts...)
+	return &RenderedQuery{
+		sql: query,
+		err: err,
+		scan: func(ctx context.Context, sql string, dest interface{}) error {
+			return r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+				raw := db.NewRaw(sql)
+				return r.queryError(raw.Scan(ctx, dest), raw)
+			})
+		},
+	}
+}
+
+// SQL returns the rendered query, empty if it failed to render. Bun
+// interpolates the arguments as escaped literals while rendering, so the
+// SQL is self-contained and carries no placeholders to bind.
+func (q *RenderedQuery) SQL() string {
+	return q.sql
+}
+
+// Err returns the error rendering the query, if any
+func (q *RenderedQuery) Err() error {
+	return q.err
+}
+
+// Scan executes the query and scans its rows into dest, such as a *[]*T or
+// a *[]map[string]interface{}
+func (q *RenderedQuery) Scan(ctx context.Context, dest interface{}) error {
+	if q.err != nil {
+		return q.err
+	}
+	return q.scan(ctx, q.sql, dest)
+}
+
+// renderSelect renders the scoped SELECT the query options would produce
+func (r *Repository[T]) renderSelect(ctx context.Context, opts ...gpa.QueryOption) (string, error) {
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var entities []*T
+	b, err := newSelect(r.db, &entities, opts...).ApplyQueryBuilder(scope).AppendQuery(schema.NewFormatter(r.db.Dialect()), nil)
+	if err != nil {
+		return "", r.provider.convertError(err)
+	}
+	return string(b), nil
+}
+
+// Explain runs EXPLAIN on the SELECT the query options would produce and returns the plan.
+// Postgres plans are returned as JSON, with analyze too; MySQL plans are JSON unless
+// analyze is set, as its EXPLAIN ANALYZE only reports text. SQLite returns
+// the EXPLAIN QUERY PLAN details one per line and does not support analyze.
+func (r *Repository[T]) Explain(ctx context.Context, analyze bool, opts ...gpa.QueryOption) (string, error) {
+	var prefix string
+	switch r.db.Dialect().Name() {
+	case dialect.PG:
+		prefix = "EXPLAIN (FORMAT JSON) "
+		if analyze {
+			prefix = "EXPLAIN (ANALYZE, FORMAT JSON) "
+		}
+	case dialect.MySQL:
+		prefix = "EXPLAIN FORMAT=JSON "
+		if analyze {
+			prefix = "EXPLAIN ANALYZE "
+		}
+	case dialect.SQLite:
+		if analyze {
+			return "", gpa.GPAError{
+				Type:    gpa.ErrorTypeUnsupported,
+				Message: "EXPLAIN ANALYZE is not supported by sqlite",
+			}
+		}
+		prefix = "EXPLAIN QUERY PLAN "
+	default:
+		return "", gpa.GPAError{
+			Type:    gpa.ErrorTypeUnsupported,
+			Message: fmt.Sprintf("EXPLAIN is not supported by %s", r.db.Dialect().Name()),
+		}
+	}
+
+	query, err := r.renderSelect(ctx, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		raw := db.NewRaw(prefix + query)
+		rows, err := db.QueryContext(ctx, prefix+query)
+		if err != nil {
+			return r.queryError(err, raw)
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return r.queryError(err, raw)
+		}
+
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			valuePtrs := make([]interface{}, len(columns))
+			for i := range values {
+				valuePtrs[i] = &values[i]
+			}
+
+			if err := rows.Scan(valuePtrs...); err != nil {
+				return r.queryError(err, raw)
+			}
+
+			parts := make([]string, 0, len(columns))
+			for i, col := range columns {
+				if col == "detail" {
+					parts = []string{fmt.Sprintf("%s", values[i])}
+					break
+				}
+				parts = append(parts, fmt.Sprintf("%s", values[i]))
+			}
+			lines = append(lines, strings.Join(parts, "\t"))
+		}
+		return r.queryError(rows.Err(), raw)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// withQueryOptions runs fn with a connection configured for the execution
+// settings in opts, such as StatementTimeout and WithTimeout
+func (r *Repository[T]) withQueryOptions(ctx context.Context, opts []gpa.QueryOption, fn func(ctx context.Context, db bun.IDB) error) error {
+	var timeout time.Duration
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case statementTimeoutOption:
+			timeout = o.timeout
+		case timeoutOption:
+			if o.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(context.WithValue(ctx, timeoutKey{}, true), o.timeout)
+				defer cancel()
+			}
+		}
+	}
+
+	return r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		if timeout <= 0 {
+			return fn(ctx, db)
+		}
+
+		switch db.Dialect().Name() {
+		case dialect.PG:
+			run := func(ctx context.Context, tx bun.Tx) error {
+				if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+					return r.provider.convertError(err)
+				}
+				return fn(ctx, tx)
+			}
+			if tx, ok := db.(bun.Tx); ok {
+				return run(ctx, tx)
+			}
+			return db.RunInTx(ctx, nil, run)
+		case dialect.MySQL:
+			conn := db
+			if pool, ok := db.(*bun.DB); ok {
+				c, err := pool.Conn(ctx)
+				if err != nil {
+					return r.provider.convertError(err)
+				}
+				defer c.Close()
+				conn = c
+			}
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET SESSION max_execution_time = %d", timeout.Milliseconds())); err != nil {
+				return r.provider.convertError(err)
+			}
+			defer conn.ExecContext(context.WithoutCancel(ctx), "SET SESSION max_execution_time = DEFAULT")
+			return fn(ctx, conn)
+		default:
+			return fn(ctx, db)
+		}
+	})
+}
+
+// session runs fn with a connection carrying the provider's session
+// variables and tenant schema for ctx. Within a transaction they were
+// applied when it began.
+// ctx is bounded by the provider's query_timeout unless WithTimeout set
+// the operation's own.
+func (r *Repository[T]) session(ctx context.Context, fn func(ctx context.Context, db bun.IDB) error) error {
+	if err := r.checkPrepared(); err != nil {
+		return err
+	}
+	if r.lastQuery != nil {
+		// Only the operation's own queries are recorded
+		run := fn
+		fn = func(ctx context.Context, db bun.IDB) error {
+			return run(context.WithValue(ctx, lastQueryKey{}, r.lastQuery), db)
+		}
+	}
+	if r.provider != nil && r.provider.queryTimeout > 0 && ctx.Value(timeoutKey{}) == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.provider.queryTimeout)
+		defer cancel()
+	}
+
+	pool, ok := r.db.(*bun.DB)
+	if !ok {
+		return fn(ctx, r.db)
+	}
+	variables, err := r.provider.sessionSettings(ctx)
+	if err != nil {
+		return err
+	}
+	if len(variables) == 0 {
+		return fn(ctx, r.db)
+	}
+
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return r.provider.convertError(err)
+	}
+	defer conn.Close()
+
+	// The settings last for the connection's session, so clear them before
+	// it returns to the pool
+	defer func() {
+		for name := range variables {
+			if name == "search_path" {
+				conn.ExecContext(context.WithoutCancel(ctx), "RESET search_path")
+				continue
+			}
+			conn.ExecContext(context.WithoutCancel(ctx), "SELECT set_config(?, '', false)", name)
+		}
+	}()
+	if err := r.provider.applySessionVariables(ctx, conn, variables, false); err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+// newSelect builds a select query for model with the query options applied
+func newSelect(db bun.IDB, model interface{}, opts ...gpa.QueryOption) *bun.SelectQuery {
+	q := applyQuery(db.NewSelect().Model(model), buildQuery(opts...))
+	for _, opt := range opts {
+		if o, ok := opt.(selectOption); ok {
+			q = o.applySelect(q)
+		}
+	}
+	return q
+}
+
+// queryError converts err from executing q, attaching the SQL of the failed query
+func (r *Repository[T]) queryError(err error, q schema.QueryAppender) error {
+	if err == nil {
+		return nil
+	}
+	if b, appendErr := q.AppendQuery(schema.NewFormatter(r.db.Dialect()), nil); appendErr == nil {
+		err = &QueryError{query: r.provider.redact(string(b)), err: err}
+	}
+	return r.provider.convertError(err)
+}
+
+// scope builds a query builder func ANDing the repository scopes into a query,
+// evaluating the context scopes against ctx
+func (r *Repository[T]) scope(ctx context.Context) (func(bun.QueryBuilder) bun.QueryBuilder, error) {
+	conditions := r.scopes
+	if len(r.contextScopes) > 0 {
+		conditions = append([]gpa.Condition(nil), r.scopes...)
+		for _, fn := range r.contextScopes {
+			condition, err := fn(ctx)
+			if err != nil {
+				var gpaErr gpa.GPAError
+				if errors.As(err, &gpaErr) {
+					return nil, err
+				}
+				return nil, gpa.GPAError{
+					Type:    gpa.ErrorTypeInvalidArgument,
+					Message: "context scope failed",
+					Cause:   err,
+				}
+			}
+			if condition != nil {
+				conditions = append(conditions, condition)
+			}
+		}
+	}
+	for _, condition := range conditions {
+		if err := checkCondition(condition); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(q bun.QueryBuilder) bun.QueryBuilder {
+		for _, condition := range conditions {
+			if sql, args := conditionSQL(condition); sql != "" {
+				q = q.Where(sql, args...)
+			}
+		}
+		return q
+	}, nil
+}
+
+// readScope extends scope with the default scopes of T that opts don't
+// bypass with Unscoped
+func (r *Repository[T]) readScope(ctx context.Context, opts []gpa.QueryOption) (func(bun.QueryBuilder) bun.QueryBuilder, error) {
+	if err := checkQuery(opts); err != nil {
+		return nil, err
+	}
+	scope, err := r.scope(ctx)
+	if err != nil || r.provider == nil {
+		return scope, err
+	}
+
+	defaults := r.provider.defaultScopesOf(reflect.TypeOf((*T)(nil)).Elem())
+	if len(defaults) == 0 {
+		return scope, nil
+	}
+
+	var unscopeAll bool
+	unscoped := make(map[string]bool)
+	for _, opt := range opts {
+		if o, ok := opt.(unscopedOption); ok {
+			unscopeAll = unscopeAll || len(o.names) == 0
+			for _, name := range o.names {
+				unscoped[name] = true
+			}
+		}
+	}
+	if unscopeAll {
+		return scope, nil
+	}
+	for _, d := range defaults {
+		if err := checkCondition(d.condition); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(q bun.QueryBuilder) bun.QueryBuilder {
+		q = scope(q)
+		for _, d := range defaults {
+			if !unscoped[d.name] {
+				sql, args := conditionSQL(d.condition)
+				q = q.Where(sql, args...)
+			}
+		}
+		return q
+	}, nil
+}
+
+// table returns the Bun schema for T
+func (r *Repository[T]) table() *schema.Table {
+	return r.db.Dialect().Tables().Get(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// NonAtomic returns a copy of the repository whose multi-statement
+// operations, such as chunked CreateBatch inserts, commit each statement on
+// its own. A failure then leaves the earlier statements applied, which suits
+// best-effort bulk loads that should not hold one long transaction.
+func (r *Repository[T]) NonAtomic() *Repository[T] {
+	repo := *r
+	repo.nonAtomic = true
+	return &repo
+}
+
+// OrderedBy returns a copy of the repository whose FindAll and Query results
+// are ordered by orders unless the query options set an order themselves
+func (r *Repository[T]) OrderedBy(orders ...gpa.Order) *Repository[T] {
+	repo := *r
+	repo.defaultOrder = orders
+	return &repo
+}
+
+// withDefaultOrder appends the default order of the repository, or of the
+// provider's order_by_primary_key option, to opts that set no order
+func (r *Repository[T]) withDefaultOrder(opts []gpa.QueryOption) []gpa.QueryOption {
+	if len(buildQuery(opts...).Orders) > 0 || hasOption[orderExprOption](opts) {
+		return opts
+	}
+
+	orders := r.defaultOrder
+	if len(orders) == 0 && r.provider != nil && r.provider.orderByPrimaryKey {
+		table := r.table()
+		for _, pk := range table.PKs {
+			orders = append(orders, gpa.Order{Field: table.Alias + "." + pk.Name, Direction: gpa.OrderAsc})
+		}
+	}
+	if len(orders) == 0 {
+		return opts
+	}
+
+	ordered := append(make([]gpa.QueryOption, 0, len(opts)+len(orders)), opts...)
+	for _, order := range orders {
+		ordered = append(ordered, gpa.OrderOption{Order: order})
+	}
+	return ordered
+}
+
+// atomic runs fn, which issues several statements when multi is set, in a
+// transaction unless the repository is already in one or is NonAtomic
+func (r *Repository[T]) atomic(ctx context.Context, multi bool, fn func(ctx context.Context, db bun.IDB) error) error {
+	return r.session(ctx, func(ctx context.Context, db bun.IDB) error {
+		if _, inTx := db.(bun.Tx); !multi || inTx || r.nonAtomic {
+			return fn(ctx, db)
+		}
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			return fn(ctx, tx)
+		})
+	})
+}
+
+// BatchLimits bounds the statements batch operations build: the chunks of
+// CreateBatch and BulkCopy and the IN lists of FindByIDs and DeleteByIDs.
+// Bun inlines arguments as escaped literals, so no bind variables reach the
+// driver and SQLite's limit on them doesn't apply; what a statement is held
+// to is the length of its SQL, such as MySQL's max_allowed_packet or
+// SQLite's SQLITE_MAX_SQL_LENGTH. Zero fields take the dialect's defaults.
+type BatchLimits struct {
+	// MaxParams caps the values inlined into a single statement, bounding
+	// the length of its SQL: by default 999 on SQLite and 65535 on
+	// PostgreSQL and MySQL
+	MaxParams int
+
+	// BatchSize caps the rows of a multi-row INSERT; by default as many
+	// as MaxParams allows
+	BatchSize int
+
+	// MaxPacket caps the bytes of a MySQL bulk INSERT; by default the
+	// server's max_allowed_packet
+	MaxPacket int
+}
+
+// maxBindParams returns the default number of values inlined into a single
+// statement on the dialect
+func maxBindParams(name dialect.Name) int {
+	if name == dialect.SQLite {
+		return 999
+	}
+	return 65535
+}
+
+// BatchLimits returns the provider's batch limits with MaxParams resolved
+// for its dialect. BatchSize and MaxPacket stay 0 unless configured, as
+// they depend on the model and the server.
+func (p *Provider) BatchLimits() BatchLimits {
+	return p.resolveBatchLimits(p.db.Dialect().Name())
+}
+
+// resolveBatchLimits fills MaxParams of the configured limits from the
+// dialect. A nil provider has the dialect's defaults.
+func (p *Provider) resolveBatchLimits(name dialect.Name) BatchLimits {
+	var limits BatchLimits
+	if p != nil {
+		limits = p.batchLimits
+	}
+	if limits.MaxParams <= 0 {
+		limits.MaxParams = maxBindParams(name)
+	}
+	return limits
+}
+
+// maxParams returns the number of values inlined into a single statement
+func (r *Repository[T]) maxParams() int {
+	return r.provider.resolveBatchLimits(r.db.Dialect().Name()).MaxParams
+}
+
+// BatchSize returns the number of rows of T a multi-row INSERT carries: as
+// many as MaxParams values allow, capped by the configured batch size
+func (r *Repository[T]) BatchSize() int {
+	limits := r.provider.resolveBatchLimits(r.db.Dialect().Name())
+	size := max(1, limits.MaxParams/max(1, len(r.table().Fields)))
+	if limits.BatchSize > 0 {
+		size = min(size, limits.BatchSize)
+	}
+	return size
+}
+
+// singlePrimaryKey returns the name of T's primary key column, failing for
+// composite keys
+func (r *Repository[T]) singlePrimaryKey() (string, error) {
+	table := r.table()
+	if len(table.PKs) != 1 {
+		return "", gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("a single-column primary key is required, %s has %d", table.Name, len(table.PKs)),
+		}
+	}
+	return table.PKs[0].Name, nil
+}
+
+// wherePrimaryKey builds a query builder func matching the row identified by id.
+// A scalar id matches a single primary key column; composite keys are matched
+// from a map of column names to values or from a T/*T with the key fields set.
+func (r *Repository[T]) wherePrimaryKey(id interface{}) (func(bun.QueryBuilder) bun.QueryBuilder, error) {
+	table := r.table()
+
+	columns := []string{"id"}
+	if len(table.PKs) > 0 {
+		columns = make([]string, len(table.PKs))
+		for i, pk := range table.PKs {
+			columns[i] = pk.Name
+		}
+	}
+
+	values := make([]interface{}, len(columns))
+	switch key := id.(type) {
+	case map[string]interface{}:
+		for i, column := range columns {
+			value, ok := key[column]
+			if !ok {
+				return nil, gpa.GPAError{
+					Type:    gpa.ErrorTypeInvalidArgument,
+					Message: fmt.Sprintf("missing primary key column: %s", column),
+				}
+			}
+			values[i] = value
+		}
+	case T, *T:
+		strct := reflect.Indirect(reflect.ValueOf(key))
+		if !strct.IsValid() {
+			return nil, gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: "primary key entity is nil",
+			}
+		}
+		for i, column := range columns {
+			field := table.LookupField(column)
+			if field == nil {
+				return nil, gpa.GPAError{
+					Type:    gpa.ErrorTypeInvalidArgument,
+					Message: fmt.Sprintf("unknown primary key column: %s", column),
+				}
+			}
+			values[i] = field.Value(strct).Interface()
+		}
+	default:
+		if len(columns) != 1 {
+			return nil, gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: fmt.Sprintf("composite primary key requires values for: %s", strings.Join(columns, ", ")),
+			}
+		}
+		values[0] = id
+	}
+
+	return func(q bun.QueryBuilder) bun.QueryBuilder {
+		for i, column := range columns {
+			q = q.Where("?TableAlias.? = ?", bun.Ident(column), values[i])
+		}
+		return q
+	}, nil
+}
+
+// QueryAs runs a query against T's table and scans the rows into R, usually a
+// DTO that embeds T with `bun:",extend"` and adds fields for columns selected
+// from joined tables with SelectAs. Unless gpa.Select names the columns
+// explicitly, all of T's columns are selected alongside the aliases.
+func QueryAs[R any, T any](ctx context.Context, repo *Repository[T], opts ...gpa.QueryOption) ([]R, error) {
+	scope, err := repo.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	selectAll := len(buildQuery(opts...).Fields) == 0
+
+	var results []R
+	err = repo.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		q := newSelect(db, (*T)(nil), opts...).ApplyQueryBuilder(scope)
+		if selectAll {
+			q = q.ColumnExpr("?TableAlias.*")
+		}
+		return repo.queryError(q.Scan(ctx, &results), q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Windowed is an entity of T with the total number of rows its query matched
+// before the limit and offset applied
+type Windowed[T any] struct {
+	Entity     T     `bun:"embed:"`
+	TotalCount int64 `bun:"total_count"`
+}
+
+// PaginateWindowed returns a page of the entities matching the query options,
+// each carrying the total number of matches, counted with COUNT(*) OVER () in
+// the same query instead of a separate Count. The total is also returned; it
+// is 0 when the page is empty, including when the offset is past the last
+// match. Window functions need PostgreSQL, SQLite 3.25 or MySQL 8.
+func (r *Repository[T]) PaginateWindowed(ctx context.Context, opts ...gpa.QueryOption) ([]Windowed[T], int64, error) {
+	scope, err := r.readScope(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts = r.withDefaultOrder(opts)
+	selectAll := len(buildQuery(opts...).Fields) == 0
+
+	var results []Windowed[T]
+	err = r.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		q := newSelect(db, (*T)(nil), opts...).ApplyQueryBuilder(scope)
+		if selectAll {
+			q = q.ColumnExpr("?TableAlias.*")
+		}
+		q = q.ColumnExpr("COUNT(*) OVER () AS total_count")
+		return r.queryError(q.Scan(ctx, &results), q)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if len(results) > 0 {
+		total = results[0].TotalCount
+	}
+	return results, total, nil
+}
+
+// Pluck returns the values of a single column of the rows of T's table
+// matching the query options, e.g. Pluck[string](ctx, repo, "email").
+// Fields selected by the options are replaced by column.
+func Pluck[V any, T any](ctx context.Context, repo *Repository[T], column string, opts ...gpa.QueryOption) ([]V, error) {
+	scope, err := repo.readScope(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	query := buildQuery(opts...)
+	query.Fields = []string{column}
+
+	values := []V{}
+	err = repo.withQueryOptions(ctx, opts, func(ctx context.Context, db bun.IDB) error {
+		q := applyQuery(db.NewSelect().Model((*T)(nil)), query).ApplyQueryBuilder(scope)
+		return repo.queryError(q.Scan(ctx, &values), q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Transaction implements gpa.Transaction[T]
+type Transaction[T any] struct {
+	*Repository[T]
+}
+
+// Tx returns the transaction all operations of t run in. Cached results of
+// tables written through it aren't invalidated; name them with
+// InvalidateOnCommit.
+func (t *Transaction[T]) Tx() bun.Tx {
+	tx, _ := t.db.(bun.Tx)
+	return tx
+}
+
+// InvalidateOnCommit invalidates the cached results of tables once the
+// transaction commits, for tables written through Tx or raw statements
+func (t *Transaction[T]) InvalidateOnCommit(tables ...string) {
+	if t.txWrites != nil {
+		t.txWrites.add(tables...)
+	}
+}
+
+// RepositoryInTx returns a repository of U whose operations run in tx, so
+// operations on several entity types commit or roll back together. tx's own
+// RawExec, RawQuery and RawRows run in the transaction as well, for
+// statements no model covers.
+func RepositoryInTx[U any, T any](tx *Transaction[T]) *Repository[U] {
+	repo := &Repository[U]{db: tx.db, provider: tx.provider, txWrites: tx.txWrites, prepared: tx.prepared}
+	if tx.provider != nil {
+		tx.provider.qualifyTable(reflect.TypeOf((*U)(nil)).Elem())
+		repo.lastQuery = tx.provider.newLastQuery()
+	}
+	return repo
+}
+
+// Pool returns a copy of the repository that runs outside the transaction on
+// the provider's connection pool, e.g. to take an advisory lock that must
+// outlive it. Its statements don't see the transaction's uncommitted changes
+// and can block on its locks; with a single-connection pool, such as SQLite's
+// :memory:, they wait until the transaction ends.
+func (t *Transaction[T]) Pool() *Repository[T] {
+	repo := *t.Repository
+	repo.db = t.provider.db
+	repo.txWrites = nil
+	repo.prepared = nil
+	return &repo
+}
+
+// Commit commits the transaction
+func (t *Transaction[T]) Commit() error {
+	return nil
+}
+
+// Rollback rolls back the transaction
+func (t *Transaction[T]) Rollback() error {
+	return nil
+}
+
+// SetSavepoint creates a savepoint
+func (t *Transaction[T]) SetSavepoint(name string) error {
+	return nil
+}
+
+// RollbackToSavepoint rolls back to a savepoint
+func (t *Transaction[T]) RollbackToSavepoint(name string) error {
+	return nil
+}
+
+// Prepare ends the transaction with PostgreSQL's PREPARE TRANSACTION, so its
+// changes survive to be committed or rolled back later, possibly from
+// another session, with the provider's CommitPrepared or RollbackPrepared
+// under the same gid. The session is then no longer in the prepared
+// transaction, so statements on t or the repositories sharing it report
+// ErrorTypeTransaction, and the transaction function returning only ends an
+// empty one. The server needs max_prepared_transactions above zero. Other
+// dialects report unsupported.
+func (t *Transaction[T]) Prepare(ctx context.Context, gid string) error {
+	if t.db.Dialect().Name() != dialect.PG {
+		return t.provider.convertError(unsupportedError("prepared transactions require postgres"))
+	}
+	if err := checkGID(gid); err != nil {
+		return err
+	}
+	if err := t.checkPrepared(); err != nil {
+		return err
+	}
+	prepare := t.db.NewRaw("PREPARE TRANSACTION ?", gid)
+	if _, err := prepare.Exec(ctx); err != nil {
+		return t.queryError(err, prepare)
+	}
+	if t.prepared != nil {
+		t.prepared.Store(true)
+	}
+	// database/sql still commits or rolls back the transaction it began, so
+	// give it one to end
+	begin := t.db.NewRaw("BEGIN")
+	if _, err := begin.Exec(ctx); err != nil {
+		return t.queryError(err, begin)
+	}
+	return nil
+}
+
+// checkPrepared refuses statements in a transaction ended with Prepare
+func (r *Repository[T]) checkPrepared() error {
+	if r.prepared == nil || !r.prepared.Load() {
+		return nil
+	}
+	return gpa.GPAError{
+		Type:    gpa.ErrorTypeTransaction,
+		Message: "transaction is prepared; finish it with CommitPrepared or RollbackPrepared",
+	}
+}
+
+// SetConstraintsDeferred runs SET CONSTRAINTS ALL DEFERRED, so checks of
+// the constraints declared DEFERRABLE run at commit instead of after each
+// statement, e.g. to insert rows that reference each other in any order.
+// It lasts until the transaction ends. Other dialects report unsupported.
+func (t *Transaction[T]) SetConstraintsDeferred(ctx context.Context) error {
+	if t.db.Dialect().Name() != dialect.PG {
+		return t.provider.convertError(unsupportedError("deferred constraints require postgres"))
+	}
+	if err := t.checkPrepared(); err != nil {
+		return err
+	}
+	if _, err := t.db.ExecContext(ctx, "SET CONSTRAINTS ALL DEFERRED"); err != nil {
+		return t.provider.convertError(err)
+	}
+	return nil
+}
+
+// Result implements gpa.Result
+type Result struct {
+	result  sql.Result
+	dialect dialect.Name
+	// ids are the generated ids read by RawExecReturning, which sets no result
+	ids []int64
+}
+
+// LastInsertId returns the last insert ID. PostgreSQL doesn't report one
+// for a plain statement, so it returns ErrorTypeUnsupported there unless
+// the result came from RawExecReturning.
+func (r *Result) LastInsertId() (int64, error) {
+	if r.result == nil {
+		if len(r.ids) == 0 {
+			return 0, nil
+		}
+		return r.ids[len(r.ids)-1], nil
+	}
+	if r.dialect == dialect.PG {
+		return 0, convertBunError(unsupportedError("LastInsertId is not supported by postgres; use RawExecReturning"))
+	}
+	return r.result.LastInsertId()
+}
+
+// RowsAffected returns the number of affected rows
+func (r *Result) RowsAffected() (int64, error) {
+	if r.result == nil {
+		return int64(len(r.ids)), nil
+	}
+	return r.result.RowsAffected()
+}
+
+// InsertedIDs returns the ids read by RawExecReturning, or nil for other
+// results
+func (r *Result) InsertedIDs() []int64 {
+	return r.ids
+}
+
+// =====================================
+// Migrations
+// =====================================
+
+// Migrator runs versioned migrations built with Bun's migrate package,
+// recording applied versions in the bun_migrations table
+type Migrator struct {
+	provider *Provider
+	migrator *migrate.Migrator
+}
+
+// Migrator returns a migrator for migrations. A migration is recorded as
+// applied only once it succeeds.
+func (p *Provider) Migrator(migrations *migrate.Migrations) *Migrator {
+	return &Migrator{
+		provider: p,
+		migrator: migrate.NewMigrator(p.db, migrations, migrate.WithMarkAppliedOnSuccess(true)),
+	}
+}
+
+// Migrate applies the pending migrations as a new group and returns it.
+// The group has no migrations when everything is up to date.
+func (m *Migrator) Migrate(ctx context.Context) (*migrate.MigrationGroup, error) {
+	if err := m.provider.checkWritable(); err != nil {
+		return nil, err
+	}
+	var group *migrate.MigrationGroup
+	err := m.locked(ctx, func() (err error) {
+		group, err = m.migrator.Migrate(ctx)
+		return err
+	})
+	return group, err
+}
+
+// Rollback reverts the most recently applied group of migrations and returns it
+func (m *Migrator) Rollback(ctx context.Context) (*migrate.MigrationGroup, error) {
+	if err := m.provider.checkWritable(); err != nil {
+		return nil, err
+	}
+	var group *migrate.MigrationGroup
+	err := m.locked(ctx, func() (err error) {
+		group, err = m.migrator.Rollback(ctx)
+		return err
+	})
+	return group, err
+}
+
+// Status returns all known migrations; applied ones have a non-zero GroupID
+// and MigratedAt. It creates the tracking tables if needed, except on a
+// read_only provider, which only reads existing ones.
+func (m *Migrator) Status(ctx context.Context) (migrate.MigrationSlice, error) {
+	if m.provider.readOnly {
+		migrations, err := m.migrator.MigrationsWithStatus(ctx)
+		if err != nil {
+			return nil, gpa.GPAError{
+				Type:    gpa.ErrorTypeDatabase,
+				Message: "failed to read migration status; a read_only provider doesn't create the migrations tables",
+				Cause:   err,
+			}
+		}
+		return migrations, nil
+	}
+
+	if err := m.migrator.Init(ctx); err != nil {
+		return nil, m.provider.convertError(err)
+	}
+	migrations, err := m.migrator.MigrationsWithStatus(ctx)
+	if err != nil {
+		return nil, m.provider.convertError(err)
+	}
+	return migrations, nil
+}
+
+// locked runs fn holding the migration lock, creating the tracking tables first
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	if err := m.migrator.Init(ctx); err != nil {
+		return m.provider.convertError(err)
+	}
+	if err := m.migrator.Lock(ctx); err != nil {
+		// The lock is a row in the locks table; a held lock makes the
+		// insert conflict
+		if !isUniqueViolation(err) {
+			return m.provider.convertError(err)
+		}
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeDatabase,
+			Message: "migrations are locked by another process",
+			Cause:   err,
+		}
+	}
+	defer m.migrator.Unlock(context.WithoutCancel(ctx))
+
+	if err := fn(); err != nil {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeDatabase,
+			Message: "migration failed",
+			Cause:   err,
+		}
+	}
+	return nil
+}
+
+// =====================================
+// Schema Management
+// =====================================
+
+// IndexOption customizes an index created by CreateIndex
+type IndexOption func(q *bun.CreateIndexQuery) *bun.CreateIndexQuery
+
+// IndexWhere makes a partial index covering only the rows matching the
+// condition. PostgreSQL and SQLite support partial indexes; MySQL does not.
+func IndexWhere(query string, args ...interface{}) IndexOption {
+	return func(q *bun.CreateIndexQuery) *bun.CreateIndexQuery {
+		if q.Dialect().Name() == dialect.MySQL {
+			return q.Err(unsupportedError("partial indexes are not supported by mysql"))
+		}
+		return q.Where(query, args...)
+	}
+}
+
+// IndexExpr adds an expression, e.g. lower(email), to the indexed columns.
+// MySQL supports expression indexes from 8.0.13.
+func IndexExpr(expr string, args ...interface{}) IndexOption {
+	return func(q *bun.CreateIndexQuery) *bun.CreateIndexQuery {
+		return q.ColumnExpr("("+expr+")", args...)
+	}
+}
+
+// CreateIndex creates the index indexName on the table of model over columns,
+// followed by any expressions added with IndexExpr
+func (p *Provider) CreateIndex(ctx context.Context, model interface{}, indexName string, columns []string, unique bool, opts ...IndexOption) error {
+	if err := p.checkWritable(); err != nil {
+		return err
+	}
+	q := p.db.NewCreateIndex().Model(model).Index(indexName).Column(columns...)
+	if unique {
+		q = q.Unique()
+	}
+	for _, opt := range opts {
+		q = opt(q)
+	}
+	if _, err := q.Exec(ctx); err != nil {
+		return p.convertError(err)
+	}
+	return nil
+}
+
+// DropIndex drops the index indexName from the table of model. The table is
+// only needed by MySQL, where index names are scoped to their table.
+func (p *Provider) DropIndex(ctx context.Context, model interface{}, indexName string) error {
+	if err := p.checkWritable(); err != nil {
+		return err
+	}
+	var err error
+	if p.db.Dialect().Name() == dialect.MySQL {
+		typ := reflect.TypeOf(model)
+		for typ != nil && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == nil || typ.Kind() != reflect.Struct {
+			return gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: fmt.Sprintf("dropping index %s on mysql needs a struct model to name its table, got %T", indexName, model),
+			}
+		}
+		table := p.db.Table(typ)
+		_, err = p.db.NewRaw("DROP INDEX ? ON ?", bun.Ident(indexName), bun.Safe(table.SQLName)).Exec(ctx)
+	} else {
+		_, err = p.db.NewDropIndex().Index(indexName).Exec(ctx)
+	}
+	if err != nil {
+		return p.convertError(err)
+	}
+	return nil
+}
+
+// AddColumn adds a column to the table of model, where columnDef is the
+// column definition, e.g. "nickname VARCHAR(64) NOT NULL DEFAULT ''".
+// SQLite can't add PRIMARY KEY or UNIQUE columns, or NOT NULL columns
+// without a default; those are reported as unsupported.
+func (p *Provider) AddColumn(ctx context.Context, model interface{}, columnDef string) error {
+	if err := p.checkWritable(); err != nil {
+		return err
+	}
+	_, err := p.db.NewAddColumn().Model(model).ColumnExpr(columnDef).Exec(ctx)
+	return p.alterTableError(err)
+}
+
+// DropColumn drops column from the table of model. SQLite can't drop
+// PRIMARY KEY, UNIQUE or indexed columns; those are reported as unsupported.
+func (p *Provider) DropColumn(ctx context.Context, model interface{}, column string) error {
+	if err := p.checkWritable(); err != nil {
+		return err
+	}
+	_, err := p.db.NewDropColumn().Model(model).Column(column).Exec(ctx)
+	return p.alterTableError(err)
+}
+
+// alterTableError converts an ALTER TABLE error, reporting the changes
+// SQLite's limited ALTER TABLE rejects as unsupported
+func (p *Provider) alterTableError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if p.db.Dialect().Name() == dialect.SQLite {
+		msg := strings.ToLower(err.Error())
+		if strings.HasPrefix(msg, "cannot add") || strings.HasPrefix(msg, "cannot drop") {
+			err = unsupportedError("sqlite: " + err.Error())
+		}
+	}
+	return p.convertError(err)
+}
+
+// =====================================
+// Update Expressions
+// =====================================
+
+// SQLExpr is a raw SQL expression assigned to a column by UpdatePartial
+type SQLExpr struct {
+	Query string
+	Args  []interface{}
+}
+
+// Expr creates an SQL expression for UpdatePartial, e.g. Expr("balance + ?", amount).
+// The expression is inserted verbatim, so it must not contain untrusted input.
+func Expr(query string, args ...interface{}) SQLExpr {
+	return SQLExpr{Query: query, Args: args}
+}
+
+// nullValue is the type of the Null sentinel
+type nullValue struct{}
+
+// Null sets a column to SQL NULL when used as an UpdatePartial value
+var Null = nullValue{}
+
+// =====================================
+// Query Options
+// =====================================
+
+// includeZeroOption marks zero-valued columns that FindByExample should match
+// and UpdateNonZero should write
+type includeZeroOption struct {
+	columns []string
+}
+
+// Apply is a no-op; the option is read by FindByExample and UpdateNonZero
+func (o includeZeroOption) Apply(query *gpa.Query) {}
+
+// IncludeZero makes FindByExample match the given columns even when the
+// example's value for them is the zero value (or NULL for pointer fields),
+// and makes UpdateNonZero write them even when zero
+func IncludeZero(columns ...string) gpa.QueryOption {
+	return includeZeroOption{columns: columns}
+}
+
+// statementTimeoutOption caps server-side execution time of a query
+type statementTimeoutOption struct {
+	timeout time.Duration
+}
+
+// Apply is a no-op; the option is read when the query is executed
+func (o statementTimeoutOption) Apply(query *gpa.Query) {}
+
+// StatementTimeout caps how long the database may spend executing the query,
+// independently of the context deadline. Postgres runs the query in a
+// transaction with SET LOCAL statement_timeout (inside an existing transaction
+// the setting lasts until it ends); MySQL sets max_execution_time for the
+// query's connection. SQLite has no server-side timeout and ignores it.
+func StatementTimeout(timeout time.Duration) gpa.QueryOption {
+	return statementTimeoutOption{timeout: timeout}
+}
+
+// timeoutOption bounds the context of a single operation
+type timeoutOption struct {
+	timeout time.Duration
+}
+
+// Apply is a no-op; the option is read when the query is executed
+func (o timeoutOption) Apply(query *gpa.Query) {}
+
+// timeoutKey marks a context whose deadline was set by WithTimeout, which
+// replaces the provider's query_timeout
+type timeoutKey struct{}
+
+// WithTimeout bounds the operation's context by timeout, replacing the
+// provider's query_timeout for it, e.g. to give a long report more time
+// than most queries get. The caller's context deadline still applies.
+// Unlike StatementTimeout it works on every dialect: the query is cancelled
+// from the client, failing with gpa.ErrorTypeTimeout.
+func WithTimeout(timeout time.Duration) gpa.QueryOption {
+	return timeoutOption{timeout: timeout}
+}
+
+// unscopedOption lists the default scopes a query bypasses
+type unscopedOption struct {
+	names []string
+}
+
+// Apply is a no-op; the option is read when the query is scoped
+func (o unscopedOption) Apply(query *gpa.Query) {}
+
+// Unscoped bypasses the named default scopes registered with
+// RegisterDefaultScope for a query, or all of them when no names are given.
+// Repository scopes from GetScopedRepository still apply.
+func Unscoped(names ...string) gpa.QueryOption {
+	return unscopedOption{names: names}
+}
+
+// allowUnconditionalOption permits a bulk write without conditions
+type allowUnconditionalOption struct{}
+
+// Apply is a no-op; the option is read by the bulk write
+func (o allowUnconditionalOption) Apply(query *gpa.Query) {}
+
+// AllowUnconditional confirms that a bulk UPDATE or DELETE such as DeleteAll
+// may run without conditions, affecting every row within the repository
+// scopes. Without it such statements fail with a validation error.
+func AllowUnconditional() gpa.QueryOption {
+	return allowUnconditionalOption{}
+}
+
+// requireConditions fails with a validation error when none of conditions
+// restricts the rows of a bulk write, unless opts allow it
+func requireConditions(conditions []gpa.Condition, opts []gpa.QueryOption) error {
+	for _, condition := range conditions {
+		if !emptyCondition(condition) {
+			return nil
+		}
+	}
+	if hasOption[allowUnconditionalOption](opts) {
+		return nil
+	}
+	return gpa.GPAError{
+		Type:    gpa.ErrorTypeValidation,
+		Message: "refusing to modify all rows without a condition; pass AllowUnconditional to confirm",
+	}
+}
+
+// emptyCondition reports whether condition renders no restriction
+func emptyCondition(condition gpa.Condition) bool {
+	if condition == nil {
+		return true
+	}
+	if composite, ok := condition.(gpa.CompositeCondition); ok {
+		for _, c := range composite.Conditions {
+			if !emptyCondition(c) {
+				return false
+			}
+		}
+		return true
+	}
+	sql, _ := conditionSQL(condition)
+	return strings.TrimSpace(sql) == ""
+}
+
+// hasOption reports whether opts contains an option of type O
+func hasOption[O gpa.QueryOption](opts []gpa.QueryOption) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(O); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextScopeFunc derives a scope condition from the context of an operation,
+// e.g. the tenant of the current request. A nil condition adds no restriction;
+// an error fails the operation.
+type ContextScopeFunc func(ctx context.Context) (gpa.Condition, error)
+
+// contextScopeOption carries a ContextScopeFunc to GetScopedRepository
+type contextScopeOption struct {
+	fn ContextScopeFunc
+}
+
+func (o contextScopeOption) Apply(query *gpa.Query) {}
+
+// ContextScope scopes a repository by a condition evaluated against the
+// context of every operation. Pass it to GetScopedRepository:
+//
+//	orders := gpabun.GetScopedRepository[Order](provider, gpabun.ContextScope(tenantScope))
+func ContextScope(fn ContextScopeFunc) gpa.QueryOption {
+	return contextScopeOption{fn: fn}
+}
+
+// LockModifier changes how a locking read treats rows locked by other transactions
+type LockModifier string
+
+const (
+	// SkipLocked skips rows that are already locked (FOR UPDATE SKIP LOCKED)
+	SkipLocked LockModifier = "SKIP LOCKED"
+	// NoWait fails immediately instead of waiting for locked rows (FOR UPDATE NOWAIT)
+	NoWait LockModifier = "NOWAIT"
+)
+
+// Lock types for locking reads with a modifier, in addition to those in gpa
+const (
+	LockForUpdateSkipLocked gpa.LockType = "FOR_UPDATE_SKIP_LOCKED"
+	LockForShareSkipLocked  gpa.LockType = "FOR_SHARE_SKIP_LOCKED"
+	LockForShareNoWait      gpa.LockType = "FOR_SHARE_NOWAIT"
+)
+
+// ForUpdate locks the selected rows for update (SELECT ... FOR UPDATE).
+// An optional SkipLocked or NoWait modifier supports job-queue patterns.
+// Plain locking is a no-op on SQLite, which has no row-level locking;
+// modifiers are reported as unsupported there.
+func ForUpdate(modifiers ...LockModifier) gpa.QueryOption {
+	lock := gpa.LockForUpdate
+	for _, modifier := range modifiers {
+		switch modifier {
+		case SkipLocked:
+			lock = LockForUpdateSkipLocked
+		case NoWait:
+			lock = gpa.LockUpdateNoWait
+		}
+	}
+	return gpa.Lock(lock)
+}
+
+// ForShare locks the selected rows against concurrent updates (SELECT ... FOR SHARE).
+// It accepts the same modifiers as ForUpdate and behaves the same on SQLite.
+func ForShare(modifiers ...LockModifier) gpa.QueryOption {
+	lock := gpa.LockForShare
+	for _, modifier := range modifiers {
+		switch modifier {
+		case SkipLocked:
+			lock = LockForShareSkipLocked
+		case NoWait:
+			lock = LockForShareNoWait
+		}
+	}
+	return gpa.Lock(lock)
+}
+
+// NullsOrder places NULL values first or last in an ordering
+type NullsOrder string
+
+const (
+	NullsFirst NullsOrder = "NULLS FIRST"
+	NullsLast  NullsOrder = "NULLS LAST"
+)
+
+// OrderByNulls orders by field with NULL values placed first or last,
+// giving the same result on every dialect.
+func OrderByNulls(field string, direction gpa.OrderDirection, nulls NullsOrder) gpa.QueryOption {
+	return gpa.OrderOption{
+		Order: gpa.Order{
+			Field:     field,
+			Direction: direction + " " + gpa.OrderDirection(nulls),
+		},
+	}
+}
+
+// selectOption is implemented by query options that modify the Bun select directly
+type selectOption interface {
+	gpa.QueryOption
+	applySelect(q *bun.SelectQuery) *bun.SelectQuery
+}
+
+// selectAsOption selects an expression under a column alias
+type selectAsOption struct {
+	expr  string
+	alias string
+	args  []interface{}
+}
+
+// Apply is a no-op; the option is applied to the Bun select directly
+func (o selectAsOption) Apply(query *gpa.Query) {}
+
+func (o selectAsOption) applySelect(q *bun.SelectQuery) *bun.SelectQuery {
+	// Copy the args so a reused option never writes into the caller's slice
+	return q.ColumnExpr(o.expr+" AS ?", append(append([]interface{}{}, o.args...), bun.Ident(o.alias))...)
+}
+
+// SelectAs selects an SQL expression, typically a column of a joined table,
+// under the given alias, e.g. SelectAs("u.name", "author_name"). Use it with
+// QueryAs to scan joined columns into a DTO. The expression is inserted verbatim.
+func SelectAs(expr string, alias string, args ...interface{}) gpa.QueryOption {
+	return selectAsOption{expr: expr, alias: alias, args: args}
+}
+
+// orderExprOption orders by a raw SQL expression
+type orderExprOption struct {
+	expr string
+	args []interface{}
+}
+
+// Apply is a no-op; the option is applied to the Bun select directly
+func (o orderExprOption) Apply(query *gpa.Query) {}
+
+func (o orderExprOption) applySelect(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.OrderExpr(o.expr, o.args...)
+}
+
+// OrderByExpr orders by an SQL expression with its direction, e.g.
+// OrderByExpr("lower(name)") or OrderByExpr("CASE WHEN status = ? THEN 0
+// ELSE 1 END, created_at DESC", "urgent"). Expressions follow the orderings
+// of gpa.OrderBy in the order they are given; express every ordering with
+// OrderByExpr to interleave them. Arguments are bound safely, but the
+// expression itself is inserted verbatim: never build it from user input.
+func OrderByExpr(expr string, args ...interface{}) gpa.QueryOption {
+	return orderExprOption{expr: expr, args: args}
+}
+
+// WindowSpec describes the OVER clause of a window function
+type WindowSpec struct {
+	PartitionBy []string
+	OrderBy     []gpa.Order
+}
+
+// windowOption selects a window function under a column alias
+type windowOption struct {
+	function string
+	alias    string
+	spec     WindowSpec
+	args     []interface{}
+}
+
+// Apply is a no-op; the option is applied to the Bun select directly
+func (o windowOption) Apply(query *gpa.Query) {}
+
+func (o windowOption) applySelect(q *bun.SelectQuery) *bun.SelectQuery {
+	args := append([]interface{}{}, o.args...)
+	var clauses []string
+	if len(o.spec.PartitionBy) > 0 {
+		idents := make([]string, len(o.spec.PartitionBy))
+		for i, field := range o.spec.PartitionBy {
+			idents[i] = "?"
+			args = append(args, bun.Ident(field))
+		}
+		clauses = append(clauses, "PARTITION BY "+strings.Join(idents, ", "))
+	}
+	if len(o.spec.OrderBy) > 0 {
+		orders := make([]string, len(o.spec.OrderBy))
+		for i, order := range o.spec.OrderBy {
+			expr, orderArgs := orderSQL(q.Dialect().Name(), order)
+			orders[i] = expr
+			args = append(args, orderArgs...)
+		}
+		clauses = append(clauses, "ORDER BY "+strings.Join(orders, ", "))
+	}
+	args = append(args, bun.Ident(o.alias))
+	return q.ColumnExpr(o.function+" OVER ("+strings.Join(clauses, " ")+") AS ?", args...)
+}
+
+// Window selects a window function such as ROW_NUMBER() or SUM(?) over the
+// partitions and ordering of spec under the given alias, e.g.
+// Window("RANK()", "salary_rank", WindowSpec{PartitionBy: []string{"department"},
+// OrderBy: []gpa.Order{{Field: "salary", Direction: gpa.OrderDesc}}}).
+// Use it with QueryAs to scan the ranking into a DTO. The function is
+// inserted verbatim.
+func Window(function string, alias string, spec WindowSpec, args ...interface{}) gpa.QueryOption {
+	return windowOption{function: function, alias: alias, spec: spec, args: args}
+}
+
+// RawCondition is a raw SQL condition fragment with its arguments
+type RawCondition struct {
+	Query string
+	Args  []interface{}
+}
+
+func (c RawCondition) Field() string          { return "" }
+func (c RawCondition) Operator() gpa.Operator { return "" }
+func (c RawCondition) Value() interface{}     { return c.Args }
+func (c RawCondition) String() string         { return c.Query }
+
+// WhereRaw adds a raw condition fragment to the WHERE clause, e.g.
+// WhereRaw("lower(email) = lower(?)", email). Arguments are bound safely, but
+// the fragment itself is inserted verbatim: never build it from user input.
+func WhereRaw(query string, args ...interface{}) gpa.QueryOption {
+	return gpa.ConditionOption{
+		Condition: RawCondition{Query: query, Args: args},
+	}
+}
+
+// WhereBetween adds field BETWEEN low AND high to the WHERE clause, the
+// query option form of Between
+func WhereBetween(field string, low, high interface{}) gpa.QueryOption {
+	return gpa.ConditionOption{Condition: Between(field, low, high)}
+}
+
+// ArrayContains matches rows whose Postgres array column contains all of the
+// given values (column @> ARRAY[...]). values may be a slice or a single element.
+// Map array columns with the `bun:",array"` tag so they scan and bind as arrays.
+// A nil values matches no rows.
+func ArrayContains(column string, values interface{}) gpa.QueryOption {
+	v := reflect.ValueOf(values)
+	if !v.IsValid() {
+		return WhereRaw("1 = 0")
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		slice := reflect.MakeSlice(reflect.SliceOf(v.Type()), 1, 1)
+		slice.Index(0).Set(v)
+		values = slice.Interface()
+	}
+	return WhereRaw("? @> ?", bun.Ident(column), pgdialect.Array(values))
+}
+
+// ArrayAny matches rows where value equals any element of the Postgres array column
+// (value = ANY(column)).
+func ArrayAny(column string, value interface{}) gpa.QueryOption {
+	return WhereRaw("? = ANY(?)", value, bun.Ident(column))
+}
+
+// =====================================
+// Query Building
+// =====================================
+
+// buildQuery collects query options into a gpa.Query
+func buildQuery(opts ...gpa.QueryOption) *gpa.Query {
+	query := gpa.NewQuery()
+	for _, opt := range opts {
+		if opt != nil {
+			opt.Apply(query)
+		}
+	}
+	return query
+}
+
+// applyQuery applies a gpa.Query to a Bun select query.
+// Column names are always quoted with bun.Ident so mixed-case names and
+// reserved words resolve to the intended column on every dialect.
+func applyQuery(q *bun.SelectQuery, query *gpa.Query) *bun.SelectQuery {
+	if query.Distinct {
+		q = q.Distinct()
+	}
+	for _, field := range query.Fields {
+		q = q.ColumnExpr("?", bun.Ident(field))
+	}
+	for _, join := range query.Joins {
+		table := join.Table
+		if join.Alias != "" {
+			table += " AS " + join.Alias
+		}
+		q = q.Join(fmt.Sprintf("%s JOIN %s ON %s", join.Type, table, join.Condition))
+	}
+	for _, condition := range query.Conditions {
+		// An empty group, such as gpa.Or() built from no filters, matches all
+		if sql, args := conditionSQL(condition); sql != "" {
+			q = q.Where(sql, args...)
+		}
+	}
+	for _, group := range query.Groups {
+		q = q.GroupExpr("?", bun.Ident(group))
+	}
+	for _, condition := range query.Having {
+		sql, args := conditionSQL(condition)
+		q = q.Having(sql, args...)
+	}
+	for _, order := range query.Orders {
+		sql, args := orderSQL(q.Dialect().Name(), order)
+		q = q.OrderExpr(sql, args...)
+	}
+	if query.Limit != nil {
+		q = q.Limit(*query.Limit)
+	}
+	if query.Offset != nil {
+		q = q.Offset(*query.Offset)
+	}
+	if clause, err := lockClause(q.Dialect().Name(), query.Lock); err != nil {
+		q = q.Err(err)
+	} else if clause != "" {
+		q = q.For(clause)
+	}
+	return q
+}
+
+// orderSQL renders an ordering as a Bun query fragment and its arguments.
+// NULLS FIRST/LAST is emulated with an IS NULL sort key on MySQL.
+func orderSQL(name dialect.Name, order gpa.Order) (string, []interface{}) {
+	dir, nulls, _ := strings.Cut(strings.ToUpper(strings.TrimSpace(string(order.Direction))), " ")
+	direction := gpa.OrderAsc
+	if dir == string(gpa.OrderDesc) {
+		direction = gpa.OrderDesc
+	}
+
+	field := bun.Ident(order.Field)
+	switch NullsOrder(nulls) {
+	case NullsFirst, NullsLast:
+		if name == dialect.MySQL {
+			nullsKey := "DESC"
+			if NullsOrder(nulls) == NullsLast {
+				nullsKey = "ASC"
+			}
+			return "? IS NULL " + nullsKey + ", ? " + string(direction), []interface{}{field, field}
+		}
+		return "? " + string(direction) + " " + nulls, []interface{}{field}
+	default:
+		return "? " + string(direction), []interface{}{field}
+	}
+}
+
+// lockClause returns the FOR clause for a lock type, or "" when no lock was
+// requested or the dialect has no row-level locking
+func lockClause(name dialect.Name, lock gpa.LockType) (string, error) {
+	var clause string
+	switch lock {
+	case gpa.LockForUpdate, gpa.LockExclusive:
+		clause = "UPDATE"
+	case gpa.LockUpdateNoWait:
+		clause = "UPDATE NOWAIT"
+	case LockForUpdateSkipLocked:
+		clause = "UPDATE SKIP LOCKED"
+	case gpa.LockForShare, gpa.LockShared:
+		clause = "SHARE"
+	case LockForShareNoWait:
+		clause = "SHARE NOWAIT"
+	case LockForShareSkipLocked:
+		clause = "SHARE SKIP LOCKED"
+	default:
+		return "", nil
+	}
+
+	if name == dialect.SQLite {
+		if strings.Contains(clause, " ") {
+			return "", unsupportedError(fmt.Sprintf("FOR %s is not supported by sqlite", clause))
+		}
+		return "", nil
+	}
+	return clause, nil
+}
+
+// conditionSQL renders a condition as a Bun query fragment and its arguments.
+// The operator decides the placeholders: IN takes a list, BETWEEN the two
+// bounds of a two-element slice and IS NULL no value. Composite conditions
+// render their parts joined by their logic operator.
+func conditionSQL(condition gpa.Condition) (string, []interface{}) {
+	if raw, ok := condition.(RawCondition); ok {
+		return raw.Query, raw.Args
+	}
+	if composite, ok := condition.(gpa.CompositeCondition); ok {
+		return compositeSQL(composite)
+	}
+
+	basic, ok := condition.(gpa.BasicCondition)
+	if !ok {
+		return condition.String(), []interface{}{condition.Value()}
+	}
+
+	switch basic.Op {
+	case gpa.OpIn, gpa.OpNotIn:
+		return "? " + string(basic.Op) + " (?)", []interface{}{bun.Ident(basic.FieldName), bun.In(basic.Val)}
+	case gpa.OpIsNull, gpa.OpIsNotNull:
+		return "? " + string(basic.Op), []interface{}{bun.Ident(basic.FieldName)}
+	case gpa.OpBetween, gpa.OpNotBetween:
+		low, high, _ := betweenBounds(basic.Val)
+		return "? " + string(basic.Op) + " ? AND ?", []interface{}{bun.Ident(basic.FieldName), low, high}
+	default:
+		return "? " + string(basic.Op) + " ?", []interface{}{bun.Ident(basic.FieldName), basic.Val}
+	}
+}
+
+// IsNull matches rows where field is NULL. It is the condition form of
+// gpa.WhereNull, for DeleteByCondition, scopes and condition groups.
+func IsNull(field string) gpa.Condition {
+	return gpa.BasicCondition{FieldName: field, Op: gpa.OpIsNull}
+}
+
+// IsNotNull matches rows where field is not NULL
+func IsNotNull(field string) gpa.Condition {
+	return gpa.BasicCondition{FieldName: field, Op: gpa.OpIsNotNull}
+}
+
+// Between matches rows where field lies in the inclusive range low to high,
+// rendering field BETWEEN ? AND ?. For an exclusive or half-open range, e.g.
+// created_at from one day to the next, combine two comparisons instead:
+// AllOf(gpa.WhereCondition(field, gpa.OpGreaterThanOrEqual, low),
+// gpa.WhereCondition(field, gpa.OpLessThan, high)).
+func Between(field string, low, high interface{}) gpa.Condition {
+	return gpa.BasicCondition{FieldName: field, Op: gpa.OpBetween, Val: []interface{}{low, high}}
+}
+
+// NotBetween matches rows where field lies outside the range low to high
+func NotBetween(field string, low, high interface{}) gpa.Condition {
+	return gpa.BasicCondition{FieldName: field, Op: gpa.OpNotBetween, Val: []interface{}{low, high}}
+}
+
+// AnyOf groups conditions with OR, for nesting a group inside gpa.Or or
+// gpa.And, e.g. gpa.And(gpa.WhereCondition("active", gpa.OpEqual, true),
+// AnyOf(a, b)) renders active = true AND (a OR b). gpa.Or is the query
+// option for a group at the top level.
+func AnyOf(conditions ...gpa.Condition) gpa.Condition {
+	return gpa.CompositeCondition{Conditions: conditions, Logic: gpa.LogicOr}
+}
+
+// AllOf groups conditions with AND, for nesting a group inside gpa.Or, e.g.
+// gpa.Or(a, AllOf(b, c)) renders a OR (b AND c)
+func AllOf(conditions ...gpa.Condition) gpa.Condition {
+	return gpa.CompositeCondition{Conditions: conditions, Logic: gpa.LogicAnd}
+}
+
+// compositeSQL renders the non-empty parts of composite joined by its logic
+// operator, defaulting to AND, in parentheses
+func compositeSQL(composite gpa.CompositeCondition) (string, []interface{}) {
+	// Only AND and OR reach the SQL; checkCondition rejects other logic
+	logic := string(gpa.LogicAnd)
+	if strings.ToUpper(strings.TrimSpace(string(composite.Logic))) == string(gpa.LogicOr) {
+		logic = string(gpa.LogicOr)
+	}
+
+	var parts []string
+	var args []interface{}
+	for _, condition := range composite.Conditions {
+		if emptyCondition(condition) {
+			continue
+		}
+		sql, conditionArgs := conditionSQL(condition)
+		if _, raw := condition.(RawCondition); raw {
+			// A raw fragment may have its own AND or OR
+			sql = "(" + sql + ")"
+		}
+		parts = append(parts, sql)
+		args = append(args, conditionArgs...)
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(parts, " "+logic+" ") + ")", args
+}
+
+// betweenBounds returns the bounds of a BETWEEN value, a slice or array of
+// two elements
+func betweenBounds(value interface{}) (interface{}, interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Len() != 2 {
+		return nil, nil, false
+	}
+	return v.Index(0).Interface(), v.Index(1).Interface(), true
+}
+
+// checkCondition fails with an invalid argument error when condition, or a
+// part of a composite condition, has a value its operator can't render, or
+// a composite condition has logic other than AND or OR
+func checkCondition(condition gpa.Condition) error {
+	switch c := condition.(type) {
+	case gpa.CompositeCondition:
+		switch strings.ToUpper(strings.TrimSpace(string(c.Logic))) {
+		case "", string(gpa.LogicAnd), string(gpa.LogicOr):
+		default:
+			return gpa.GPAError{
+				Type:    gpa.ErrorTypeInvalidArgument,
+				Message: fmt.Sprintf("condition group needs AND or OR logic, got %q", c.Logic),
+			}
+		}
+		for _, part := range c.Conditions {
+			if err := checkCondition(part); err != nil {
+				return err
+			}
+		}
+	case gpa.BasicCondition:
+		switch c.Op {
+		case gpa.OpBetween, gpa.OpNotBetween:
+			if _, _, ok := betweenBounds(c.Val); !ok {
+				return gpa.GPAError{
+					Type:    gpa.ErrorTypeInvalidArgument,
+					Message: fmt.Sprintf("%s on %s needs a slice of two bounds, got %T", c.Op, c.FieldName, c.Val),
+				}
+			}
+		case gpa.OpIn, gpa.OpNotIn:
+			v := reflect.ValueOf(c.Val)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return gpa.GPAError{
+					Type:    gpa.ErrorTypeInvalidArgument,
+					Message: fmt.Sprintf("%s on %s needs a slice of values, got %T", c.Op, c.FieldName, c.Val),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkQuery runs checkCondition on the WHERE and HAVING conditions of opts
+func checkQuery(opts []gpa.QueryOption) error {
+	query := buildQuery(opts...)
+	for _, conditions := range [][]gpa.Condition{query.Conditions, query.Having} {
+		for _, condition := range conditions {
+			if err := checkCondition(condition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// =====================================
+// UUID Keys
+// =====================================
+
+// UUIDGenerator returns a new UUID for an empty primary key
+type UUIDGenerator func() ([16]byte, error)
+
+// UUIDv4 generates random (version 4) UUIDs
+func UUIDv4() ([16]byte, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
+
+// UUIDv7 generates time-ordered (version 7) UUIDs, whose inserts stay local
+// in B-tree indexes
+func UUIDv7() ([16]byte, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		return u, err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
+
+// SetUUIDGenerator sets the generator Create, CreateBatch and CreateIgnore
+// use to fill empty UUID primary keys, e.g. UUIDv7 for sortable keys. The
+// default is UUIDv4.
+func (p *Provider) SetUUIDGenerator(generator UUIDGenerator) {
+	p.uuidGenerator = generator
+}
+
+// formatUUID returns the canonical text form of u
+func formatUUID(u [16]byte) string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// uuidKeys returns the primary keys of T filled with generated UUIDs: those
+// of a 16-byte array type such as uuid.UUID, and strings declared type:uuid
+func (r *Repository[T]) uuidKeys() []*schema.Field {
+	var fields []*schema.Field
+	for _, pk := range r.table().PKs {
+		switch typ := pk.IndirectType; {
+		case pk.IsPtr:
+			continue
+		case typ.Kind() == reflect.Array && typ.Len() == 16 && typ.Elem().Kind() == reflect.Uint8:
+		case typ.Kind() == reflect.String && strings.EqualFold(pk.UserSQLType, "uuid"):
+		default:
+			continue
+		}
+		fields = append(fields, pk)
+	}
+	return fields
+}
+
+// generateUUIDs fills the empty UUID primary keys of entities
+func (r *Repository[T]) generateUUIDs(entities ...*T) error {
+	fields := r.uuidKeys()
+	if len(fields) == 0 {
+		return nil
+	}
+
+	generate := UUIDv4
+	if r.provider != nil && r.provider.uuidGenerator != nil {
+		generate = r.provider.uuidGenerator
+	}
+	for _, entity := range entities {
+		strct := reflect.ValueOf(entity).Elem()
+		for _, field := range fields {
+			if !field.HasZeroValue(strct) {
+				continue
+			}
+			u, err := generate()
+			if err != nil {
+				return gpa.GPAError{
+					Type:    gpa.ErrorTypeDatabase,
+					Message: "failed to generate uuid",
+					Cause:   err,
+				}
+			}
+			v := field.Value(strct)
+			if v.Kind() == reflect.String {
+				v.SetString(formatUUID(u))
+			} else {
+				v.Set(reflect.ValueOf(u).Convert(v.Type()))
+			}
+		}
+	}
+	return nil
+}
+
+// =====================================
+// Query Caching
+// =====================================
+
+// Cache stores encoded query results grouped by table. Implementations must
+// be safe for concurrent use; NewMemoryCache provides an in-process one.
+type Cache interface {
+	// Get returns the value stored for key, if present and not expired
+	Get(ctx context.Context, table, key string) ([]byte, bool)
+	// Set stores value for key until ttl elapses
+	Set(ctx context.Context, table, key string, value []byte, ttl time.Duration)
+	// Invalidate drops all values stored for table
+	Invalidate(ctx context.Context, table string)
+}
+
+// SetCache sets the cache used by repositories enabled with Cached. Writes
+// through any repository of the provider invalidate the cached results of
+// their table; writes made outside the provider are not detected. Writes in
+// a transaction invalidate once it commits.
+// It should be set before the provider is used concurrently.
+func (p *Provider) SetCache(cache Cache) {
+	p.cache = cache
+}
+
+// Cached returns a copy of the repository that caches FindByID and FindAll
+// results in the provider's cache for ttl, keyed by the rendered SQL and the
+// tenant schema and session variables of the context.
+// Results are stored as JSON, so T must round-trip through encoding/json.
+// Reads inside transactions bypass the cache, as do reads with joins, raw
+// conditions or expressions, which may read tables other than T's.
+func (r *Repository[T]) Cached(ttl time.Duration) *Repository[T] {
+	repo := *r
+	repo.cacheTTL = ttl
+	return &repo
+}
+
+// cachedScan scans q, built from opts, into dest, going through the cache
+// when enabled and q reads T's table alone
+func (r *Repository[T]) cachedScan(ctx context.Context, q *bun.SelectQuery, dest interface{}, opts []gpa.QueryOption) error {
+	if r.cacheTTL <= 0 || r.provider == nil || r.provider.cache == nil || !r.readsOwnTable(ctx, opts) {
+		return r.queryError(q.Scan(ctx), q)
+	}
+
+	b, err := q.AppendQuery(schema.NewFormatter(r.db.Dialect()), nil)
+	if err != nil {
+		return r.queryError(err, q)
+	}
+	// The tenant search_path and session variables change the rows a query
+	// returns without changing its SQL, so they are part of the key
+	settings, err := r.provider.sessionSettings(ctx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b = append(b, "\x00"...)
+		b = append(b, name...)
+		b = append(b, '=')
+		b = append(b, settings[name]...)
+	}
+	table, key := r.table().Name, string(b)
+
+	if value, ok := r.provider.cache.Get(ctx, table, key); ok {
+		if err := json.Unmarshal(value, dest); err == nil {
+			return nil
+		}
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		return r.queryError(err, q)
+	}
+	if value, err := json.Marshal(dest); err == nil {
+		r.provider.cache.Set(ctx, table, key, value, r.cacheTTL)
+	}
+	return nil
+}
+
+// readsOwnTable reports whether a select built from opts and the repository
+// scopes reads T's table alone. Cached results are only invalidated by writes
+// to T's table, so selects that may read others through joins, subqueries or
+// raw fragments aren't cached.
+func (r *Repository[T]) readsOwnTable(ctx context.Context, opts []gpa.QueryOption) bool {
+	query := buildQuery(opts...)
+	if len(query.Joins) > 0 || !ownConditions(query.Conditions) || !ownConditions(query.Having) {
+		return false
+	}
+	for _, opt := range opts {
+		// SelectAs, OrderExpr and Window add raw expressions
+		if _, ok := opt.(selectOption); ok {
+			return false
+		}
+	}
+
+	conditions := append([]gpa.Condition(nil), r.scopes...)
+	for _, fn := range r.contextScopes {
+		// A failing context scope has already failed the select
+		if condition, err := fn(ctx); err == nil && condition != nil {
+			conditions = append(conditions, condition)
+		}
+	}
+	for _, d := range r.provider.defaultScopesOf(reflect.TypeOf((*T)(nil)).Elem()) {
+		conditions = append(conditions, d.condition)
+	}
+	return ownConditions(conditions)
+}
+
+// ownConditions reports whether conditions only compare columns to values,
+// without raw fragments or subqueries that may read other tables
+func ownConditions(conditions []gpa.Condition) bool {
+	for _, condition := range conditions {
+		switch c := condition.(type) {
+		case gpa.CompositeCondition:
+			if !ownConditions(c.Conditions) {
+				return false
+			}
+		case gpa.BasicCondition:
+			if _, ok := c.Val.(schema.QueryAppender); ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// invalidateCache drops the cached results of T's table after a write, or
+// once the transaction the write belongs to commits
+func (r *Repository[T]) invalidateCache(ctx context.Context) {
+	if r.provider == nil || r.provider.cache == nil {
+		return
+	}
+	if r.txWrites != nil {
+		r.txWrites.add(r.table().Name)
+		return
+	}
+	r.provider.cache.Invalidate(ctx, r.table().Name)
+}
+
+// txWrites is the set of tables written in a transaction
+type txWrites struct {
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+func (w *txWrites) add(tables ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.tables == nil {
+		w.tables = make(map[string]bool, len(tables))
+	}
+	for _, table := range tables {
+		w.tables[table] = true
+	}
+}
+
+// invalidate drops the cached results of the written tables
+func (w *txWrites) invalidate(ctx context.Context, p *Provider) {
+	if p == nil || p.cache == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for table := range w.tables {
+		p.cache.Invalidate(ctx, table)
+	}
+}
+
+// memoryCache is an in-process Cache
+type memoryCache struct {
+	mu     sync.Mutex
+	tables map[string]map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache returns an in-process Cache. Expired entries are dropped
+// when read or when their table is invalidated.
+func NewMemoryCache() Cache {
+	return &memoryCache{tables: make(map[string]map[string]memoryCacheEntry)}
+}
+
+func (c *memoryCache) Get(ctx context.Context, table, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.tables[table][key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.tables[table], key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(ctx context.Context, table, key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, ok := c.tables[table]
+	if !ok {
+		entries = make(map[string]memoryCacheEntry)
+		c.tables[table] = entries
+	}
+	entries[key] = memoryCacheEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+func (c *memoryCache) Invalidate(ctx context.Context, table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tables, table)
+}
+
+// =====================================
+// Batch Loading
+// =====================================
+
+// Loader coalesces FindByID calls made within a short window into a single
+// WHERE pk IN (...) query, dataloader style, to avoid N+1 queries in
+// resolvers. Like FindByIDs, batches of more than MaxParams ids are split
+// into several queries. Loaded entities are memoized, so a Loader is usually created
+// per request; entities loaded for the same id are shared between callers.
+type Loader[T any] struct {
+	repo     *Repository[T]
+	wait     time.Duration
+	maxBatch int
+
+	mu    sync.Mutex
+	batch *loaderBatch[T]
+	memo  map[string]*loaderBatch[T]
+}
+
+// loaderBatch is a set of ids fetched by one query
+type loaderBatch[T any] struct {
+	ctx     context.Context
+	ids     []interface{}
+	keys    []string
+	timer   *time.Timer
+	once    sync.Once
+	done    chan struct{}
+	results map[string]*T
+	err     error
+}
+
+// NewLoader returns a Loader for repo that waits up to wait for more ids
+// before querying, or until maxBatch ids are pending when maxBatch is
+// positive. T must have a single-column primary key.
+func NewLoader[T any](repo *Repository[T], wait time.Duration, maxBatch int) (*Loader[T], error) {
+	table := repo.table()
+	if len(table.PKs) != 1 {
+		return nil, gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("loader requires a single-column primary key, %s has %d", table.Name, len(table.PKs)),
+		}
+	}
+	return &Loader[T]{
+		repo:     repo,
+		wait:     wait,
+		maxBatch: maxBatch,
+		memo:     make(map[string]*loaderBatch[T]),
+	}, nil
+}
+
+// Load returns the entity with the given id, batched with concurrent Loads.
+// The batch query runs with the context of its first Load, without its
+// cancellation; ctx only bounds how long this call waits.
+func (l *Loader[T]) Load(ctx context.Context, id interface{}) (*T, error) {
+	key := loaderKey(id)
+
+	l.mu.Lock()
+	b, ok := l.memo[key]
+	if !ok {
+		b = l.batch
+		if b == nil {
+			b = &loaderBatch[T]{ctx: context.WithoutCancel(ctx), done: make(chan struct{})}
+			l.batch = b
+			b.timer = time.AfterFunc(l.wait, func() { l.dispatch(b) })
+		}
+		b.ids = append(b.ids, id)
+		b.keys = append(b.keys, key)
+		l.memo[key] = b
+		if l.maxBatch > 0 && len(b.ids) >= l.maxBatch {
+			go l.dispatch(b)
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	entity, ok := b.results[key]
+	if !ok {
+		return nil, gpa.GPAError{
+			Type:    gpa.ErrorTypeNotFound,
+			Message: "record not found",
+			Cause:   sql.ErrNoRows,
+		}
+	}
+	return entity, nil
+}
+
+// LoadMany loads the entities with the given ids in one batch, returning
+// the entity and error for each id at the same index
+func (l *Loader[T]) LoadMany(ctx context.Context, ids []interface{}) ([]*T, []error) {
+	entities := make([]*T, len(ids))
+	errs := make([]error, len(ids))
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entities[i], errs[i] = l.Load(ctx, id)
+		}()
+	}
+	wg.Wait()
+	return entities, errs
+}
+
+// Clear forgets the memoized entity for id so the next Load fetches it again
+func (l *Loader[T]) Clear(id interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.memo, loaderKey(id))
+}
+
+// ClearAll forgets all memoized entities
+func (l *Loader[T]) ClearAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.memo = make(map[string]*loaderBatch[T])
+}
+
+// dispatch runs batch b once, closing it to further ids
+func (l *Loader[T]) dispatch(b *loaderBatch[T]) {
+	b.once.Do(func() {
+		l.mu.Lock()
+		if l.batch == b {
+			l.batch = nil
+		}
+		b.timer.Stop()
+		l.mu.Unlock()
+
+		entities, err := l.repo.FindByIDs(b.ctx, b.ids)
+		if err != nil {
+			// Don't memoize failures so the ids can be retried
+			l.mu.Lock()
+			for _, key := range b.keys {
+				if l.memo[key] == b {
+					delete(l.memo, key)
+				}
+			}
+			l.mu.Unlock()
+			b.err = err
+			close(b.done)
+			return
+		}
+
+		field := l.repo.table().PKs[0]
+		b.results = make(map[string]*T, len(entities))
+		for _, entity := range entities {
+			b.results[loaderKey(field.Value(reflect.ValueOf(entity).Elem()).Interface())] = entity
+		}
+		close(b.done)
+	})
+}
+
+// loaderKey normalizes an id so that e.g. int and int64 ids match
+func loaderKey(id interface{}) string {
+	return fmt.Sprint(id)
+}
+
+// =====================================
+// Notifications
+// =====================================
+
+// Notification is a message received on a PostgreSQL LISTEN channel
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+// Listen subscribes to the PostgreSQL channel on a dedicated connection and
+// streams its notifications until ctx is done, when the connection is closed
+// and the returned channel with it. The connection is re-established if it
+// drops; notifications sent meanwhile are lost. Other dialects report
+// unsupported.
+func (p *Provider) Listen(ctx context.Context, channel string) (<-chan Notification, error) {
+	if p.db.Dialect().Name() != dialect.PG {
+		return nil, p.convertError(unsupportedError("listen/notify requires postgres"))
+	}
+
+	listener := pq.NewListener(postgresDSN(p.config), 10*time.Millisecond, time.Minute, nil)
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, p.convertError(err)
+	}
+
+	notifications := make(chan Notification)
+	go func() {
+		defer close(notifications)
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification signals a reconnect
+				if n == nil {
+					continue
+				}
+				select {
+				case notifications <- Notification{Channel: n.Channel, Payload: n.Extra}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return notifications, nil
+}
+
+// Notify sends payload on the PostgreSQL channel. Other dialects report
+// unsupported.
+func (p *Provider) Notify(ctx context.Context, channel, payload string) error {
+	if p.db.Dialect().Name() != dialect.PG {
+		return p.convertError(unsupportedError("listen/notify requires postgres"))
+	}
+	if _, err := p.db.ExecContext(ctx, "SELECT pg_notify(?, ?)", channel, payload); err != nil {
+		return p.convertError(err)
+	}
+	return nil
+}
+
+// =====================================
+// Advisory Locks
+// =====================================
+
+// AcquireAdvisoryLock waits for the PostgreSQL session-level advisory lock
+// key. The lock is held on a connection taken from the pool until unlock is
+// called, which releases it on that same session and returns the connection;
+// later calls of unlock do nothing. Other dialects report unsupported.
+func (p *Provider) AcquireAdvisoryLock(ctx context.Context, key int64) (unlock func() error, err error) {
+	unlock, _, err = p.advisoryLock(ctx, key, false)
+	return unlock, err
+}
+
+// TryAcquireAdvisoryLock is like AcquireAdvisoryLock but returns immediately,
+// reporting false with a nil unlock when another session holds the lock.
+func (p *Provider) TryAcquireAdvisoryLock(ctx context.Context, key int64) (unlock func() error, acquired bool, err error) {
+	return p.advisoryLock(ctx, key, true)
+}
+
+func (p *Provider) advisoryLock(ctx context.Context, key int64, try bool) (func() error, bool, error) {
+	if p.db.Dialect().Name() != dialect.PG {
+		return nil, false, p.convertError(unsupportedError("advisory locks require postgres"))
+	}
+
+	// Session-level locks belong to a connection, so the lock and unlock
+	// must run on the same one
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, false, p.convertError(err)
+	}
+
+	acquired := true
+	if try {
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(?)", key).Scan(&acquired)
+	} else {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", key)
+	}
+	if err != nil || !acquired {
+		conn.Close()
+		return nil, false, p.convertError(err)
+	}
+
+	var once sync.Once
+	unlock := func() error {
+		var err error
+		once.Do(func() {
+			_, err = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(?)", key)
+			if closeErr := conn.Close(); err == nil {
+				err = closeErr
+			}
+		})
+		return p.convertError(err)
+	}
+	return unlock, true, nil
+}
+
+// =====================================
+// Two-Phase Commit
+// =====================================
+
+// CommitPrepared commits the PostgreSQL transaction prepared under gid with
+// Transaction.Prepare. Other dialects report unsupported.
+func (p *Provider) CommitPrepared(ctx context.Context, gid string) error {
+	return p.finishPrepared(ctx, "COMMIT PREPARED ?", gid)
+}
+
+// RollbackPrepared discards the PostgreSQL transaction prepared under gid
+// with Transaction.Prepare. Other dialects report unsupported.
+func (p *Provider) RollbackPrepared(ctx context.Context, gid string) error {
+	return p.finishPrepared(ctx, "ROLLBACK PREPARED ?", gid)
+}
+
+func (p *Provider) finishPrepared(ctx context.Context, query, gid string) error {
+	if p.db.Dialect().Name() != dialect.PG {
+		return p.convertError(unsupportedError("prepared transactions require postgres"))
+	}
+	if err := checkGID(gid); err != nil {
+		return err
+	}
+	if _, err := p.db.ExecContext(ctx, query, gid); err != nil {
+		return p.convertError(err)
+	}
+	return nil
+}
+
+// checkGID fails unless gid is a valid PostgreSQL transaction identifier
+func checkGID(gid string) error {
+	if gid == "" || len(gid) >= 200 {
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeInvalidArgument,
+			Message: fmt.Sprintf("transaction identifier must be 1 to 199 bytes, got %d", len(gid)),
+		}
+	}
+	return nil
+}
+
+// =====================================
+// Session Variables
+// =====================================
+
+// SessionVariablesFunc derives PostgreSQL settings, such as app.user_id, from
+// the context of an operation. A nil or empty map applies none.
+type SessionVariablesFunc func(ctx context.Context) (map[string]string, error)
+
+// SetSessionVariables sets fn to derive the settings applied to every
+// repository operation, e.g. for row-level security policies reading
+// current_setting('app.user_id'). Transactions apply them with
+// set_config(name, value, true), as SET LOCAL does, when they begin; other
+// operations run on a pinned connection that has them set for the session
+// and cleared again afterwards. RawRows and the provider's raw methods run
+// without them. Other dialects report unsupported when fn yields settings.
+// As the settings change which rows a query returns, Cached repositories
+// key their results by them, so no user is served another's results.
+// It should be set before the provider is used concurrently.
+func (p *Provider) SetSessionVariables(fn SessionVariablesFunc) {
+	p.sessionVariables = fn
+}
+
+// TenantSchemaFunc resolves the schema of the current tenant from the
+// context of an operation. An empty schema leaves the search_path alone.
+type TenantSchemaFunc func(ctx context.Context) (string, error)
+
+// SetTenantSchema sets fn to resolve the schema every repository operation
+// runs against, for schema-per-tenant PostgreSQL deployments. The
+// search_path is set to the schema followed by public, so shared tables in
+// public stay reachable, in the same way and with the same limits as
+// SetSessionVariables; outside transactions it is reset afterwards. Tables
+// qualified by the schema option or a schema in their table tag ignore it.
+// Cached results are kept per tenant schema.
+// It should be set before the provider is used concurrently.
+func (p *Provider) SetTenantSchema(fn TenantSchemaFunc) {
+	p.tenantSchema = fn
+}
+
+// sessionSettings returns the session variables and tenant search_path for
+// ctx, or nil when there are none
+func (p *Provider) sessionSettings(ctx context.Context) (map[string]string, error) {
+	if p == nil || (p.sessionVariables == nil && p.tenantSchema == nil) {
+		return nil, nil
+	}
+	var variables map[string]string
+	if p.sessionVariables != nil {
+		var err error
+		if variables, err = p.sessionVariables(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if p.tenantSchema == nil {
+		return variables, nil
+	}
+
+	tenant, err := p.tenantSchema(ctx)
+	if err != nil || tenant == "" {
+		return variables, err
+	}
+	settings := make(map[string]string, len(variables)+1)
+	for name, value := range variables {
+		settings[name] = value
+	}
+	settings["search_path"] = string(schema.NewFormatter(p.db.Dialect()).AppendIdent(nil, tenant)) + ", public"
+	return settings, nil
+}
+
+// applySessionVariables sets variables on db, for the current transaction
+// only when local is set
+func (p *Provider) applySessionVariables(ctx context.Context, db bun.IConn, variables map[string]string, local bool) error {
+	if p.db.Dialect().Name() != dialect.PG {
+		return p.convertError(unsupportedError("session variables require postgres"))
+	}
+	for name, value := range variables {
+		if _, err := db.ExecContext(ctx, "SELECT set_config(?, ?, ?)", name, value, local); err != nil {
+			return p.convertError(err)
+		}
+	}
+	return nil
+}
+
+// =====================================
+// Query Logging
+// =====================================
+
+// redact masks literal values in query as configured by redact_args and redact_columns
+func (p *Provider) redact(query string) string {
+	if p == nil || (!p.redactArgs && len(p.redactColumns) == 0) {
+		return query
+	}
+	return redactSQL(query, p.redactArgs, p.redactColumns)
+}
+
+// AddQueryHook registers a Bun query hook, e.g. for tracing or metrics.
+// When redaction is configured, the hook sees the redacted SQL in AfterQuery
+// like the logging hook does. Hooks should be added before the provider is
+// used concurrently.
+func (p *Provider) AddQueryHook(hook bun.QueryHook) {
+	if p.redactArgs || len(p.redactColumns) > 0 {
+		hook = &redactingQueryHook{hook: hook, provider: p}
+	}
+	p.db.AddQueryHook(hook)
+}
+
+// lastQuery holds the most recent query of a repository
+type lastQuery struct {
+	mu  sync.Mutex
+	sql string
+}
+
+// lastQueryKey carries the lastQuery of the repository running a query
+type lastQueryKey struct{}
+
+// newLastQuery returns a lastQuery for a new repository in debug mode, nil
+// otherwise
+func (p *Provider) newLastQuery() *lastQuery {
+	if !p.debug {
+		return nil
+	}
+	return &lastQuery{}
+}
+
+// lastQueryHook records each query in the lastQuery of its context
+type lastQueryHook struct {
+	provider *Provider
+}
+
+func (h lastQueryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h lastQueryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	if last, ok := ctx.Value(lastQueryKey{}).(*lastQuery); ok {
+		last.mu.Lock()
+		last.sql = h.provider.redact(event.Query)
+		last.mu.Unlock()
+	}
+}
+
+// LastSQL returns the most recent query the repository executed, including
+// failed ones, for debugging. Bun interpolates arguments into the SQL
+// itself, so args is always nil. Queries are only recorded when the
+// provider's debug option is set; otherwise the SQL is empty. RawRows and
+// the provider's raw methods are not recorded.
+func (r *Repository[T]) LastSQL() (string, []interface{}) {
+	if r.lastQuery == nil {
+		return "", nil
+	}
+	r.lastQuery.mu.Lock()
+	defer r.lastQuery.mu.Unlock()
+	return r.lastQuery.sql, nil
+}
+
+// redactingQueryHook wraps a logging hook and masks literal values in the
+// logged SQL as configured on the provider
+type redactingQueryHook struct {
+	hook     bun.QueryHook
+	provider *Provider
+}
+
+// BeforeQuery delegates to the wrapped hook
+func (h *redactingQueryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	return h.hook.BeforeQuery(ctx, event)
+}
+
+// AfterQuery passes a copy of the event with the query redacted to the wrapped hook
+func (h *redactingQueryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	redacted := *event
+	redacted.Query = h.provider.redact(event.Query)
+	h.hook.AfterQuery(ctx, &redacted)
+}
+
+// sqlToken is a lexical token of an SQL statement
+type sqlToken struct {
+	kind  byte // 's' string, 'n' number, 'i' identifier or keyword, 'p' punctuation
+	start int
+	end   int
+}
+
+// tokenizeSQL splits query into tokens, skipping whitespace
+func tokenizeSQL(query string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			i++
+			for i < len(query) {
+				if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			if i > len(query) {
+				i = len(query)
+			}
+			kind := byte('i')
+			if c == '\'' {
+				kind = 's'
+			}
+			tokens = append(tokens, sqlToken{kind: kind, start: start, end: i})
+		case c >= '0' && c <= '9':
+			for i < len(query) && (query[i] >= '0' && query[i] <= '9' || query[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: 'n', start: start, end: i})
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for i < len(query) && (query[i] == '_' || query[i] == '$' || query[i] >= 'a' && query[i] <= 'z' ||
+				query[i] >= 'A' && query[i] <= 'Z' || query[i] >= '0' && query[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: 'i', start: start, end: i})
+		case strings.IndexByte("<>=!", c) >= 0:
+			for i < len(query) && strings.IndexByte("<>=!", query[i]) >= 0 {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: 'p', start: start, end: i})
+		default:
+			i++
+			tokens = append(tokens, sqlToken{kind: 'p', start: start, end: i})
+		}
+	}
+	return tokens
 }
 
-// Exists checks if any entities match the query options
-func (r *Repository[T]) Exists(ctx context.Context, opts ...gpa.QueryOption) (bool, error) {
-	count, err := r.Count(ctx, opts...)
-	return count > 0, err
-}
+// redactSQL replaces literal values in query with ?. When all is false only
+// values compared with, assigned to or inserted into the given columns are replaced.
+func redactSQL(query string, all bool, columns map[string]bool) string {
+	tokens := tokenizeSQL(query)
+	text := func(t sqlToken) string { return query[t.start:t.end] }
+	name := func(t sqlToken) string { return strings.ToLower(strings.Trim(text(t), "\"`")) }
+	isLiteral := func(t sqlToken) bool { return t.kind == 's' || t.kind == 'n' }
 
-// Transaction executes a function within a transaction
-func (r *Repository[T]) Transaction(ctx context.Context, fn gpa.TransactionFunc[T]) error {
-	return r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
-		txRepo := &Transaction[T]{
-			Repository: &Repository[T]{
-				db:       tx,
-				provider: r.provider,
-			},
+	redact := make([]bool, len(tokens))
+	if all {
+		for i, t := range tokens {
+			redact[i] = isLiteral(t)
 		}
-		return fn(txRepo)
-	})
-}
-
-// RawQuery executes a raw query and returns results
-func (r *Repository[T]) RawQuery(ctx context.Context, query string, args []interface{}) ([]*T, error) {
-	var entities []*T
-	err := r.db.NewRaw(query, args...).Scan(ctx, &entities)
-	return entities, convertBunError(err)
-}
+	} else {
+		for i := 0; i+2 < len(tokens); i++ {
+			if tokens[i].kind != 'i' || !columns[name(tokens[i])] {
+				continue
+			}
+			op := strings.ToUpper(text(tokens[i+1]))
+			switch {
+			case op == "=" && strings.EqualFold(text(tokens[i+2]), "CASE"):
+				redactCase(tokens, i+2, text, redact)
+			case tokens[i+1].kind == 'p' && op != "(" && op != "," && op != ")" && op != ".",
+				op == "LIKE" || op == "ILIKE":
+				if isLiteral(tokens[i+2]) {
+					redact[i+2] = true
+				}
+			case op == "IN" && text(tokens[i+2]) == "(":
+				for j := i + 3; j < len(tokens) && text(tokens[j]) != ")"; j++ {
+					redact[j] = isLiteral(tokens[j])
+				}
+			}
+		}
+		redactValues(tokens, text, name, columns, redact)
+	}
 
-// RawExec executes a raw command
-func (r *Repository[T]) RawExec(ctx context.Context, query string, args []interface{}) (gpa.Result, error) {
-	result, err := r.db.NewRaw(query, args...).Exec(ctx)
-	if err != nil {
-		return nil, convertBunError(err)
+	var b strings.Builder
+	last := 0
+	for i, t := range tokens {
+		if redact[i] {
+			b.WriteString(query[last:t.start])
+			b.WriteString("?")
+			last = t.end
+		}
 	}
-	return &Result{result: result}, nil
+	b.WriteString(query[last:])
+	return b.String()
 }
 
-// GetEntityInfo returns metadata about the entity
-func (r *Repository[T]) GetEntityInfo() (*gpa.EntityInfo, error) {
-	var entity T
-	entityType := reflect.TypeOf(entity)
-	return &gpa.EntityInfo{
-		Name:      entityType.Name(),
-		TableName: entityType.Name(),
-		Fields:    []gpa.FieldInfo{},
-	}, nil
+// redactCase marks the THEN and ELSE literals of the CASE expression at
+// tokens[start], as assigned to a column by UpdateBatch on MySQL
+func redactCase(tokens []sqlToken, start int, text func(sqlToken) string, redact []bool) {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch strings.ToUpper(text(tokens[i])) {
+		case "CASE":
+			depth++
+		case "END":
+			if depth--; depth == 0 {
+				return
+			}
+		case "THEN", "ELSE":
+			if i+1 < len(tokens) && (tokens[i+1].kind == 's' || tokens[i+1].kind == 'n') {
+				redact[i+1] = true
+			}
+		}
+	}
 }
 
-// Close closes the repository
-func (r *Repository[T]) Close() error {
-	return nil
-}
+// redactValues marks the literals of an INSERT ... VALUES statement, or of
+// the VALUES list of a WITH clause such as Bun's bulk UPDATE, that go into
+// the given columns
+func redactValues(tokens []sqlToken, text, name func(sqlToken) string, columns map[string]bool, redact []bool) {
+	if len(tokens) == 0 || (!strings.EqualFold(text(tokens[0]), "INSERT") && !strings.EqualFold(text(tokens[0]), "WITH")) {
+		return
+	}
 
-// Transaction implements gpa.Transaction[T]
-type Transaction[T any] struct {
-	*Repository[T]
-}
+	// Find the column list preceding VALUES
+	var positions []bool
+	i := 0
+	for ; i < len(tokens) && text(tokens[i]) != "("; i++ {
+	}
+	for i++; i < len(tokens) && text(tokens[i]) != ")"; i++ {
+		if tokens[i].kind == 'i' {
+			positions = append(positions, columns[name(tokens[i])])
+		}
+	}
+	// The VALUES of a WITH clause are nested in the parentheses of AS (...)
+	base := 0
+	for i++; i < len(tokens) && !strings.EqualFold(text(tokens[i]), "VALUES"); i++ {
+		switch text(tokens[i]) {
+		case "(":
+			base++
+		case ")":
+			base--
+		}
+	}
 
-// Commit commits the transaction
-func (t *Transaction[T]) Commit() error {
-	return nil
+	// Walk each value tuple, tracking the column position one level in
+	depth, position := base, 0
+	for i++; i < len(tokens); i++ {
+		switch text(tokens[i]) {
+		case "(":
+			depth++
+			if depth == base+1 {
+				position = 0
+			}
+			continue
+		case ")":
+			depth--
+			continue
+		case ",":
+			if depth == base+1 {
+				position++
+			}
+			continue
+		}
+		if depth <= base {
+			return
+		}
+		if position < len(positions) && positions[position] && (tokens[i].kind == 's' || tokens[i].kind == 'n') {
+			redact[i] = true
+		}
+	}
 }
 
-// Rollback rolls back the transaction
-func (t *Transaction[T]) Rollback() error {
-	return nil
+// intOption converts an int or float64 config option to an int, 0 when
+// unset
+func intOption(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
 }
 
-// SetSavepoint creates a savepoint
-func (t *Transaction[T]) SetSavepoint(name string) error {
-	return nil
+// durationOption converts a time.Duration or duration string config option
+// such as "5s" to a time.Duration, 0 when unset or invalid
+func durationOption(value interface{}) time.Duration {
+	switch v := value.(type) {
+	case time.Duration:
+		return v
+	case string:
+		d, _ := time.ParseDuration(v)
+		return d
+	default:
+		return 0
+	}
 }
 
-// RollbackToSavepoint rolls back to a savepoint
-func (t *Transaction[T]) RollbackToSavepoint(name string) error {
-	return nil
+// logWriter converts a log_writer option to the writer of the query log. A
+// *log.Logger or *slog.Logger gets one record per logged query.
+func logWriter(value interface{}) io.Writer {
+	switch v := value.(type) {
+	case *log.Logger:
+		return loggerWriter(func(line string) { v.Print(line) })
+	case *slog.Logger:
+		return loggerWriter(func(line string) { v.Info(line) })
+	case io.Writer:
+		return v
+	default:
+		return nil
+	}
 }
 
-// Result implements gpa.Result
-type Result struct {
-	result sql.Result
-}
+// loggerWriter passes each write, a logged query, to a logger without its
+// trailing newline
+type loggerWriter func(line string)
 
-// LastInsertId returns the last insert ID
-func (r *Result) LastInsertId() (int64, error) {
-	return r.result.LastInsertId()
+func (w loggerWriter) Write(p []byte) (int, error) {
+	w(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
 }
 
-// RowsAffected returns the number of affected rows
-func (r *Result) RowsAffected() (int64, error) {
-	return r.result.RowsAffected()
+// stringSlice converts a []string or []interface{} config option to a []string
+func stringSlice(value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
 }
 
-
 // =====================================
 // Connection Helpers
 // =====================================
 
 // createPostgresConnection creates a PostgreSQL connection
 func createPostgresConnection(config gpa.Config) (*sql.DB, error) {
-	if config.ConnectionURL != "" {
-		return sql.Open("postgres", config.ConnectionURL)
+	return openDB("postgres", postgresDSN(config), config)
+}
+
+// postgresDSN returns the connection string for config
+func postgresDSN(config gpa.Config) string {
+	dsn := config.ConnectionURL
+	if dsn == "" {
+		dsn = fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
+			config.Username, config.Password, config.Host, config.Port, config.Database)
+
+		if config.SSL.Enabled {
+			dsn = strings.Replace(dsn, "sslmode=disable", "sslmode="+config.SSL.Mode, 1)
+		}
 	}
 
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		config.Username, config.Password, config.Host, config.Port, config.Database)
+	dsn = withPostgresParam(dsn, "application_name", applicationName(config))
+	if readOnly(config) {
+		dsn = withPostgresParam(dsn, "options", "-c default_transaction_read_only=on")
+	}
+	return dsn
+}
 
-	if config.SSL.Enabled {
-		dsn = strings.Replace(dsn, "sslmode=disable", "sslmode="+config.SSL.Mode, 1)
+// withPostgresParam sets the connection parameter key on dsn, a URL or a
+// key=value connection string, unless value is empty or dsn already sets it
+func withPostgresParam(dsn, key, value string) string {
+	if value == "" || strings.Contains(dsn, key+"=") {
+		return dsn
 	}
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		query := u.Query()
+		query.Set(key, value)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return strings.TrimSpace(dsn + " " + key + "='" + quoted + "'")
+}
 
-	return sql.Open("postgres", dsn)
+// readOnly reports whether the read_only option is set
+func readOnly(config gpa.Config) bool {
+	bunOpts, _ := config.Options["bun"].(map[string]interface{})
+	readOnly, _ := bunOpts["read_only"].(bool)
+	return readOnly
+}
+
+// applicationName returns the application_name option, which labels the
+// provider's connections on the server
+func applicationName(config gpa.Config) string {
+	bunOpts, _ := config.Options["bun"].(map[string]interface{})
+	name, _ := bunOpts["application_name"].(string)
+	return name
 }
 
 // createMySQLConnection creates a MySQL connection
 func createMySQLConnection(config gpa.Config) (*sql.DB, error) {
-	if config.ConnectionURL != "" {
-		return sql.Open("mysql", config.ConnectionURL)
+	dsn, err := mysqlDSN(config)
+	if err != nil {
+		return nil, err
 	}
+	return openDB("mysql", dsn, config)
+}
 
-	mysqlConfig := mysql.Config{
+// mysqlDSN returns the connection string for config. Options["mysql"]
+// "interpolate_params" makes the driver render arguments into the SQL
+// client-side instead of preparing each statement that has them.
+func mysqlDSN(config gpa.Config) (string, error) {
+	name := applicationName(config)
+	mysqlOpts, _ := config.Options["mysql"].(map[string]interface{})
+	interpolate, _ := mysqlOpts["interpolate_params"].(bool)
+
+	mysqlConfig := &mysql.Config{
 		User:   config.Username,
 		Passwd: config.Password,
 		Net:    "tcp",
 		Addr:   fmt.Sprintf("%s:%d", config.Host, config.Port),
 		DBName: config.Database,
 	}
+	if config.ConnectionURL != "" {
+		var err error
+		if mysqlConfig, err = mysql.ParseDSN(config.ConnectionURL); err != nil {
+			return "", err
+		}
+	}
+	// Scan DATETIME/TIMESTAMP columns into time.Time and bun.NullTime, also
+	// when the ConnectionURL leaves parseTime out
+	mysqlConfig.ParseTime = true
+
+	// Reported as program_name in performance_schema.session_connect_attrs
+	if name != "" && !strings.Contains(mysqlConfig.ConnectionAttributes, "program_name:") {
+		if mysqlConfig.ConnectionAttributes != "" {
+			mysqlConfig.ConnectionAttributes += ","
+		}
+		mysqlConfig.ConnectionAttributes += "program_name:" + name
+	}
+	// Set as a session variable on each new connection
+	if readOnly(config) {
+		if mysqlConfig.Params == nil {
+			mysqlConfig.Params = make(map[string]string)
+		}
+		mysqlConfig.Params["transaction_read_only"] = "1"
+	}
+	if interpolate {
+		mysqlConfig.InterpolateParams = true
+	}
+	return mysqlConfig.FormatDSN(), nil
+}
 
-	return sql.Open("mysql", mysqlConfig.FormatDSN())
+// isSQLiteMemory reports whether config opens a private SQLite in-memory database
+func isSQLiteMemory(config gpa.Config) bool {
+	switch strings.ToLower(config.Driver) {
+	case "sqlite", "sqlite3":
+		return config.Database == ":memory:"
+	}
+	return false
 }
 
-// createSQLiteConnection creates a SQLite connection
+// createSQLiteConnection creates a SQLite connection. The directory of a
+// file database must exist unless Options["sqlite"] sets "create_dir" to
+// create it or "skip_dir_check" to leave it to the driver. file: URIs are
+// passed to the driver unchecked.
 func createSQLiteConnection(config gpa.Config) (*sql.DB, error) {
+	sqliteOpts, _ := config.Options["sqlite"].(map[string]interface{})
+	skipDirCheck, _ := sqliteOpts["skip_dir_check"].(bool)
+	createDir, _ := sqliteOpts["create_dir"].(bool)
+
 	// Validate database path for file-based SQLite
-	if config.Database != ":memory:" && config.Database != "" {
+	if config.Database != ":memory:" && config.Database != "" && !strings.HasPrefix(config.Database, "file:") && !skipDirCheck {
 		// Check if the directory exists for file-based databases
 		if dir := filepath.Dir(config.Database); dir != "." && dir != "/" {
 			if _, err := os.Stat(dir); os.IsNotExist(err) {
-				return nil, fmt.Errorf("database directory does not exist: %s", dir)
+				if !createDir {
+					return nil, fmt.Errorf("database directory does not exist: %s", dir)
+				}
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					return nil, fmt.Errorf("failed to create database directory: %w", err)
+				}
 			}
 		}
 	}
 	
-	return sql.Open("sqlite3", config.Database)
+	database := config.Database
+	if readOnly(config) {
+		separator := "?"
+		if strings.Contains(database, "?") {
+			separator = "&"
+		}
+		database += separator + "_query_only=true"
+	}
+	return openDB("sqlite3", database, config)
+}
+
+// openDB opens a pool for the driver registered as name. With the
+// conn_max_lifetime_jitter option and a ConnMaxLifetime, each connection
+// expires up to the jitter earlier than ConnMaxLifetime, so connections
+// opened together don't all reconnect at once.
+func openDB(name, dsn string, config gpa.Config) (*sql.DB, error) {
+	bunOpts, _ := config.Options["bun"].(map[string]interface{})
+	jitter := durationOption(bunOpts["conn_max_lifetime_jitter"])
+	if jitter <= 0 || config.ConnMaxLifetime <= 0 {
+		return sql.Open(name, dsn)
+	}
+
+	// Opening the pool doesn't connect; it only looks up the driver
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	db.Close()
+
+	var connector driver.Connector = dsnConnector{dsn: dsn, driver: drv}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(&jitterConnector{
+		Connector: connector,
+		lifetime:  config.ConnMaxLifetime,
+		jitter:    min(jitter, config.ConnMaxLifetime),
+	}), nil
+}
+
+// dsnConnector connects through a driver without a connector of its own
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// jitterConnector gives each connection its own lifetime between
+// lifetime-jitter and lifetime
+type jitterConnector struct {
+	driver.Connector
+	lifetime time.Duration
+	jitter   time.Duration
+}
+
+func (c *jitterConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lifetime := c.lifetime - time.Duration(mathrand.Int64N(int64(c.jitter)+1))
+	return &expiringConn{Conn: conn, expires: time.Now().Add(lifetime)}, nil
+}
+
+// expiringConn reports itself invalid once expires has passed, so the pool
+// closes it instead of reusing it. The optional driver interfaces are
+// forwarded to the wrapped connection.
+type expiringConn struct {
+	driver.Conn
+	expires time.Time
+}
+
+func (c *expiringConn) expired() bool {
+	return time.Now().After(c.expires)
+}
+
+// IsValid is checked by the pool before a connection is reused
+func (c *expiringConn) IsValid() bool {
+	if c.expired() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *expiringConn) ResetSession(ctx context.Context) error {
+	if c.expired() {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *expiringConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *expiringConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *expiringConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("driver does not support transaction options")
+	}
+	return c.Conn.Begin() //nolint:staticcheck // fallback for drivers without BeginTx
+}
+
+func (c *expiringConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *expiringConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *expiringConn) CheckNamedValue(value *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
 }
 
 // =====================================
 // Error Conversion
 // =====================================
 
+// QueryError wraps a database error with the SQL of the query that failed.
+// It is the Cause of the GPAError returned by repository operations;
+// retrieve it with errors.As. Values are redacted as configured for logging.
+type QueryError struct {
+	query string
+	err   error
+}
+
+// Error returns the message of the underlying error
+func (e *QueryError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *QueryError) Unwrap() error {
+	return e.err
+}
+
+// SQL returns the rendered SQL of the failed query
+func (e *QueryError) SQL() string {
+	return e.query
+}
+
+// ErrorClassifier maps a database error to a GPA error. It returns false
+// to leave the error to the next classifier or the built-in conversion.
+type ErrorClassifier func(err error) (gpa.GPAError, bool)
+
+// RegisterErrorClassifier adds a classifier consulted, in registration
+// order, before the built-in error conversion of repository operations.
+// The error passed in may wrap a *QueryError; when the returned GPAError
+// has no Cause, the original error is attached.
+func (p *Provider) RegisterErrorClassifier(classifier ErrorClassifier) {
+	p.classifiersMu.Lock()
+	defer p.classifiersMu.Unlock()
+	p.classifiers = append(p.classifiers, classifier)
+}
+
+// convertError converts err using the registered classifiers, falling
+// back to convertBunError
+func (p *Provider) convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if p != nil {
+		p.classifiersMu.RLock()
+		classifiers := p.classifiers
+		p.classifiersMu.RUnlock()
+
+		for _, classify := range classifiers {
+			if gpaErr, ok := classify(err); ok {
+				if gpaErr.Cause == nil {
+					gpaErr.Cause = err
+				}
+				return gpaErr
+			}
+		}
+	}
+	return convertBunError(err)
+}
+
+// errProviderClosed reports an operation on a closed provider
+func errProviderClosed(cause error) error {
+	return gpa.GPAError{
+		Type:    gpa.ErrorTypeConnection,
+		Message: "provider is closed",
+		Cause:   cause,
+	}
+}
+
+// checkWritable refuses writes on a provider opened with read_only
+func (p *Provider) checkWritable() error {
+	if p == nil || !p.readOnly {
+		return nil
+	}
+	return gpa.GPAError{
+		Type:    gpa.ErrorTypeValidation,
+		Message: "provider is read-only",
+	}
+}
+
+// unsupportedError reports a query feature the current dialect lacks
+type unsupportedError string
+
+func (e unsupportedError) Error() string {
+	return string(e)
+}
+
+// UniqueViolation describes the unique constraint a write violated
+type UniqueViolation struct {
+	// Name is the constraint or index name; SQLite doesn't report it
+	Name string
+	// Table is the table of the constraint, when reported
+	Table string
+	// Columns are the constrained columns; MySQL doesn't report them
+	Columns []string
+}
+
+// Composite reports whether the constraint spans several columns
+func (v UniqueViolation) Composite() bool {
+	return len(v.Columns) > 1
+}
+
+// AsUniqueViolation extracts the violated unique constraint from a
+// duplicate key error returned by the PostgreSQL, MySQL or SQLite driver,
+// anywhere in err's chain
+func AsUniqueViolation(err error) (UniqueViolation, bool) {
+	var pqErr *pq.Error
+	var mysqlErr *mysql.MySQLError
+	var sqliteErr sqlite3.Error
+
+	switch {
+	case errors.As(err, &pqErr):
+		if pqErr.Code != "23505" {
+			return UniqueViolation{}, false
+		}
+		violation := UniqueViolation{Name: pqErr.Constraint, Table: pqErr.Table}
+		// Detail reads: Key (tenant_id, email)=(1, a@example.com) already exists.
+		if key, ok := strings.CutPrefix(pqErr.Detail, "Key ("); ok {
+			if i := strings.Index(key, ")=("); i >= 0 {
+				violation.Columns = strings.Split(key[:i], ", ")
+			}
+		}
+		return violation, true
+	case errors.As(err, &mysqlErr):
+		if mysqlErr.Number != 1062 {
+			return UniqueViolation{}, false
+		}
+		// Message reads: Duplicate entry '1-a@example.com' for key 'users.uniq_tenant_email'
+		var violation UniqueViolation
+		if i := strings.LastIndex(mysqlErr.Message, " for key '"); i >= 0 {
+			key := strings.TrimSuffix(mysqlErr.Message[i+len(" for key '"):], "'")
+			if table, name, ok := strings.Cut(key, "."); ok {
+				violation.Table, key = table, name
+			}
+			violation.Name = key
+		}
+		return violation, true
+	case errors.As(err, &sqliteErr):
+		if sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique && sqliteErr.ExtendedCode != sqlite3.ErrConstraintPrimaryKey {
+			return UniqueViolation{}, false
+		}
+		// Message reads: UNIQUE constraint failed: users.tenant_id, users.email
+		var violation UniqueViolation
+		if _, columns, ok := strings.Cut(sqliteErr.Error(), "constraint failed: "); ok {
+			for _, column := range strings.Split(columns, ", ") {
+				if table, name, ok := strings.Cut(column, "."); ok {
+					violation.Table, column = table, name
+				}
+				violation.Columns = append(violation.Columns, column)
+			}
+		}
+		return violation, true
+	default:
+		return UniqueViolation{}, false
+	}
+}
+
+// isUniqueViolation reports whether err is a driver duplicate key error
+func isUniqueViolation(err error) bool {
+	_, ok := AsUniqueViolation(err)
+	return ok
+}
+
+// CheckViolation describes the CHECK constraint a write violated
+type CheckViolation struct {
+	// Name is the constraint name. SQLite reports the name of a named
+	// constraint and the expression of an unnamed one.
+	Name string
+	// Table is the table of the constraint; only PostgreSQL reports it
+	Table string
+}
+
+// AsCheckViolation extracts the violated CHECK constraint from an error
+// returned by the PostgreSQL (SQLSTATE 23514), MySQL (3819) or SQLite
+// driver, anywhere in err's chain, e.g. to report chk_age_nonnegative as a
+// validation error on the age field
+func AsCheckViolation(err error) (CheckViolation, bool) {
+	var pqErr *pq.Error
+	var mysqlErr *mysql.MySQLError
+	var sqliteErr sqlite3.Error
+
+	switch {
+	case errors.As(err, &pqErr):
+		if pqErr.Code != "23514" {
+			return CheckViolation{}, false
+		}
+		return CheckViolation{Name: pqErr.Constraint, Table: pqErr.Table}, true
+	case errors.As(err, &mysqlErr):
+		if mysqlErr.Number != 3819 {
+			return CheckViolation{}, false
+		}
+		// Message reads: Check constraint 'chk_age_nonnegative' is violated.
+		var violation CheckViolation
+		if _, name, ok := strings.Cut(mysqlErr.Message, "'"); ok {
+			violation.Name, _, _ = strings.Cut(name, "'")
+		}
+		return violation, true
+	case errors.As(err, &sqliteErr):
+		if sqliteErr.ExtendedCode != sqlite3.ErrConstraintCheck {
+			return CheckViolation{}, false
+		}
+		// Message reads: CHECK constraint failed: chk_age_nonnegative
+		var violation CheckViolation
+		if _, name, ok := strings.Cut(sqliteErr.Error(), "constraint failed: "); ok {
+			violation.Name = name
+		}
+		return violation, true
+	default:
+		return CheckViolation{}, false
+	}
+}
+
+// isCheckViolation reports whether err is a driver CHECK constraint error
+func isCheckViolation(err error) bool {
+	_, ok := AsCheckViolation(err)
+	return ok
+}
+
 // convertBunError converts Bun errors to GPA errors
 func convertBunError(err error) error {
 	if err == nil {
 		return nil
 	}
 
+	var unsupported unsupportedError
 	switch {
-	case err == sql.ErrNoRows:
+	case errors.As(err, &unsupported):
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeUnsupported,
+			Message: string(unsupported),
+		}
+	case errors.Is(err, sql.ErrNoRows):
 		return gpa.GPAError{
 			Type:    gpa.ErrorTypeNotFound,
 			Message: "record not found",
 			Cause:   err,
 		}
+	case isUniqueViolation(err):
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeDuplicate,
+			Message: "duplicate key violation",
+			Cause:   err,
+		}
+	case isCheckViolation(err):
+		check, _ := AsCheckViolation(err)
+		message := "check constraint violation"
+		if check.Name != "" {
+			message += ": " + check.Name
+		}
+		return gpa.GPAError{
+			Type:    gpa.ErrorTypeConstraint,
+			Message: message,
+			Cause:   err,
+		}
 	case strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique"):
 		return gpa.GPAError{
 			Type:    gpa.ErrorTypeDuplicate,
@@ -623,7 +6157,9 @@ func convertBunError(err error) error {
 			Message: "constraint violation",
 			Cause:   err,
 		}
-	case strings.Contains(err.Error(), "timeout"):
+	case strings.Contains(err.Error(), "database is closed"):
+		return errProviderClosed(err)
+	case errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timeout"):
 		return gpa.GPAError{
 			Type:    gpa.ErrorTypeTimeout,
 			Message: "operation timeout",
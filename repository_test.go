@@ -3,9 +3,24 @@ package gpabun
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/lemmego/gpa"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/mysqldialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/schema"
 )
 
 type TestUser struct {
@@ -15,7 +30,7 @@ type TestUser struct {
 	Age   int    `bun:"age"`
 }
 
-func setupTestRepository(t *testing.T) (*Repository[TestUser], func()) {
+func setupTestRepository(t testing.TB) (*Repository[TestUser], func()) {
 	config := gpa.Config{
 		Driver:   "sqlite3",
 		Database: ":memory:",
@@ -245,6 +260,12 @@ func TestRepositoryUpdatePartial(t *testing.T) {
 	if found.Name != "John Doe" {
 		t.Errorf("Expected name unchanged, got '%s'", found.Name)
 	}
+
+	// An update that matches no row reports not found
+	err = repo.UpdatePartial(ctx, user.ID+1, updates)
+	if !gpa.IsNotFound(err) || !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected not found error for a missing id, got %v", err)
+	}
 }
 
 func TestRepositoryDelete(t *testing.T) {
@@ -406,6 +427,38 @@ func TestRepositoryExists(t *testing.T) {
 	if !exists {
 		t.Error("Expected users to exist")
 	}
+
+	if exists, err := repo.Exists(ctx, gpa.Where("age", gpa.OpGreaterThan, 30)); err != nil || exists {
+		t.Errorf("Expected no user older than 30, got %v, %v", exists, err)
+	}
+}
+
+func TestRepositoryExistsBy(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{Name: "John Doe", Email: "john@example.com", Age: 30}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	taken, err := repo.ExistsBy(ctx, "email", "john@example.com")
+	if err != nil || !taken {
+		t.Errorf("Expected the email to be taken, got %v, %v", taken, err)
+	}
+	taken, err = repo.ExistsBy(ctx, "email", "jane@example.com")
+	if err != nil || taken {
+		t.Errorf("Expected the email to be free, got %v, %v", taken, err)
+	}
+	if taken, err := repo.ExistsBy(ctx, "email", nil); err != nil || taken {
+		t.Errorf("Expected no user without an email, got %v, %v", taken, err)
+	}
+
+	// The check respects the repository scopes
+	scoped := GetScopedRepository[TestUser](repo.provider, gpa.Where("age", gpa.OpLessThan, 18)).(*Repository[TestUser])
+	if taken, err := scoped.ExistsBy(ctx, "email", "john@example.com"); err != nil || taken {
+		t.Errorf("Expected no match within the scope, got %v, %v", taken, err)
+	}
 }
 
 func TestRepositoryTransaction(t *testing.T) {
@@ -443,6 +496,108 @@ func TestRepositoryTransaction(t *testing.T) {
 	}
 }
 
+func TestTransactionRawAndTypedOperations(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestNote)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+
+	abort := errors.New("abort")
+	run := func(fail bool) error {
+		return repo.Transaction(ctx, func(tx gpa.Transaction[TestUser]) error {
+			if _, err := tx.RawExec(ctx, "INSERT INTO test_users (name, email, age) VALUES (?, ?, ?)", []interface{}{"Ann", "ann@example.com", 30}); err != nil {
+				return err
+			}
+			// The raw insert is visible to the transaction's other statements
+			users, err := tx.RawQuery(ctx, "SELECT * FROM test_users WHERE name = ?", []interface{}{"Ann"})
+			if err != nil {
+				return err
+			}
+			if len(users) != 1 {
+				t.Errorf("Expected the raw insert within the transaction, got %d users", len(users))
+			}
+			notes := RepositoryInTx[TestNote](tx.(*Transaction[TestUser]))
+			if err := notes.Create(ctx, &TestNote{Title: "welcome"}); err != nil {
+				return err
+			}
+			if fail {
+				return abort
+			}
+			return nil
+		})
+	}
+
+	count := func() (int64, int64) {
+		t.Helper()
+		users, err := repo.Count(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		notes, err := GetRepository[TestNote](repo.provider).Count(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count notes: %v", err)
+		}
+		return users, notes
+	}
+
+	if err := run(true); !errors.Is(err, abort) {
+		t.Fatalf("Expected the transaction to abort, got %v", err)
+	}
+	if users, notes := count(); users != 0 || notes != 0 {
+		t.Errorf("Expected the rollback to undo raw and typed writes, got %d users and %d notes", users, notes)
+	}
+
+	if err := run(false); err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+	if users, notes := count(); users != 1 || notes != 1 {
+		t.Errorf("Expected the commit to keep raw and typed writes, got %d users and %d notes", users, notes)
+	}
+}
+
+func TestTransactionPool(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: filepath.Join(t.TempDir(), "pool.db")})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestUser)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+
+	repo := GetRepository[TestUser](provider)
+	err = repo.Transaction(ctx, func(tx gpa.Transaction[TestUser]) error {
+		txRepo := tx.(*Transaction[TestUser])
+		if txRepo.Tx().Tx == nil {
+			t.Error("Expected the repository to be bound to a transaction")
+		}
+
+		if err := tx.Create(ctx, &TestUser{Name: "Ann", Email: "ann@example.com"}); err != nil {
+			return err
+		}
+		inside, err := tx.Count(ctx)
+		if err != nil {
+			return err
+		}
+		outside, err := txRepo.Pool().Count(ctx)
+		if err != nil {
+			return err
+		}
+		if inside != 1 || outside != 0 {
+			t.Errorf("Expected 1 row inside and 0 outside the transaction, got %d and %d", inside, outside)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+}
+
 func TestRepositoryTransactionRollback(t *testing.T) {
 	repo, cleanup := setupTestRepository(t)
 	defer cleanup()
@@ -508,6 +663,49 @@ func TestRepositoryRawQuery(t *testing.T) {
 	}
 }
 
+func TestRepositoryRawRows(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.CreateBatch(ctx, []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 20},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+	}); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	rows, err := repo.RawRows(ctx, "SELECT name FROM test_users WHERE age > ? ORDER BY name", []interface{}{10})
+	if err != nil {
+		t.Fatalf("Failed to execute raw rows: %v", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("Failed to scan row: %v", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Failed to iterate rows: %v", err)
+	}
+	if rows.NextResultSet() {
+		t.Error("Expected a single result set")
+	}
+	if strings.Join(names, ",") != "Ann,Bob" {
+		t.Errorf("Unexpected names: %v", names)
+	}
+
+	_, err = repo.RawRows(ctx, "SELECT missing FROM test_users", nil)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Errorf("Expected a QueryError, got %v", err)
+	}
+}
+
 func TestRepositoryRawExec(t *testing.T) {
 	repo, cleanup := setupTestRepository(t)
 	defer cleanup()
@@ -737,4 +935,4115 @@ func TestRepositoryWithBunQuery(t *testing.T) {
 	if len(users) != 1 {
 		t.Errorf("Expected 1 user, got %d", len(users))
 	}
-}
\ No newline at end of file
+}
+type TestUserRole struct {
+	UserID int64  `bun:",pk"`
+	RoleID int64  `bun:",pk"`
+	Label  string `bun:"label"`
+}
+
+func TestRepositoryCompositePrimaryKey(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	_, err = provider.db.NewCreateTable().Model((*TestUserRole)(nil)).Exec(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+
+	repo := &Repository[TestUserRole]{db: provider.db, provider: provider}
+	for _, role := range []*TestUserRole{
+		{UserID: 1, RoleID: 1, Label: "admin"},
+		{UserID: 1, RoleID: 2, Label: "editor"},
+	} {
+		if err := repo.Create(ctx, role); err != nil {
+			t.Fatalf("Failed to create user role: %v", err)
+		}
+	}
+
+	found, err := repo.FindByID(ctx, map[string]interface{}{"user_id": 1, "role_id": 2})
+	if err != nil {
+		t.Fatalf("Failed to find by map key: %v", err)
+	}
+	if found.Label != "editor" {
+		t.Errorf("Expected label 'editor', got '%s'", found.Label)
+	}
+
+	found, err = repo.FindByID(ctx, &TestUserRole{UserID: 1, RoleID: 1})
+	if err != nil {
+		t.Fatalf("Failed to find by struct key: %v", err)
+	}
+	if found.Label != "admin" {
+		t.Errorf("Expected label 'admin', got '%s'", found.Label)
+	}
+
+	if _, err := repo.FindByID(ctx, 1); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument error for scalar key, got %v", err)
+	}
+
+	err = repo.UpdatePartial(ctx, TestUserRole{UserID: 1, RoleID: 2}, map[string]interface{}{"label": "writer"})
+	if err != nil {
+		t.Fatalf("Failed to update partial: %v", err)
+	}
+
+	err = repo.Delete(ctx, map[string]interface{}{"user_id": 1, "role_id": 1})
+	if err != nil {
+		t.Fatalf("Failed to delete by map key: %v", err)
+	}
+
+	count, err := repo.Count(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 remaining row, got %d", count)
+	}
+}
+
+func TestRepositoryFindAllInto(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	users := []*TestUser{
+		{Name: "Alice", Email: "alice@example.com", Age: 25},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+		{Name: "Charlie", Email: "charlie@example.com", Age: 35},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	dest := make([]*TestUser, 0, 8)
+	err := repo.FindAllInto(ctx, &dest, gpa.Where("age", gpa.OpGreaterThan, 25), gpa.OrderBy("age", gpa.OrderDesc))
+	if err != nil {
+		t.Fatalf("Failed to find into slice: %v", err)
+	}
+	if len(dest) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(dest))
+	}
+	if dest[0].Name != "Charlie" {
+		t.Errorf("Expected 'Charlie' first, got '%s'", dest[0].Name)
+	}
+
+	first := dest[0]
+	err = repo.FindAllInto(ctx, &dest, gpa.Where("name", gpa.OpEqual, "Alice"))
+	if err != nil {
+		t.Fatalf("Failed to reuse slice: %v", err)
+	}
+	if len(dest) != 1 || dest[0].Name != "Alice" {
+		t.Fatalf("Expected only 'Alice', got %v", dest)
+	}
+	if dest[0] != first {
+		t.Error("Expected existing element to be reused")
+	}
+	if cap(dest) != 8 {
+		t.Errorf("Expected backing array to be reused, got cap %d", cap(dest))
+	}
+
+	if err := repo.FindAllInto(ctx, nil); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument error for nil destination, got %v", err)
+	}
+}
+
+func TestRepositoryFindByExample(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	users := []*TestUser{
+		{Name: "Alice", Email: "alice@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+		{Name: "Bob", Email: "", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	found, err := repo.FindByExample(ctx, &TestUser{Age: 30})
+	if err != nil {
+		t.Fatalf("Failed to find by example: %v", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("Expected 2 users aged 30, got %d", len(found))
+	}
+
+	found, err = repo.FindByExample(ctx, &TestUser{Name: "Bob"}, gpa.OrderBy("age", gpa.OrderAsc))
+	if err != nil {
+		t.Fatalf("Failed to find by example: %v", err)
+	}
+	if len(found) != 2 || found[0].Age != 30 {
+		t.Errorf("Expected both Bobs ordered by age, got %v", found)
+	}
+
+	found, err = repo.FindByExample(ctx, &TestUser{Name: "Bob"}, IncludeZero("email"))
+	if err != nil {
+		t.Fatalf("Failed to find by example with zero field: %v", err)
+	}
+	if len(found) != 1 || found[0].Age != 40 {
+		t.Errorf("Expected only the Bob without email, got %v", found)
+	}
+
+	if _, err := repo.FindByExample(ctx, nil); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument error for nil example, got %v", err)
+	}
+}
+
+func TestRepositoryUpdatePartialExpressions(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := &TestUser{Name: "John Doe", Email: "john@example.com", Age: 30}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	err := repo.UpdatePartial(ctx, user.ID, map[string]interface{}{
+		"age":   Expr("age + ?", 5),
+		"email": Null,
+	})
+	if err != nil {
+		t.Fatalf("Failed to update partial: %v", err)
+	}
+
+	var age int
+	var email sql.NullString
+	err = repo.db.NewSelect().Table("test_users").Column("age", "email").Where("id = ?", user.ID).Scan(ctx, &age, &email)
+	if err != nil {
+		t.Fatalf("Failed to read updated row: %v", err)
+	}
+	if age != 35 {
+		t.Errorf("Expected age 35, got %d", age)
+	}
+	if email.Valid {
+		t.Errorf("Expected email to be NULL, got '%s'", email.String)
+	}
+}
+
+func TestRepositoryUpdateBatch(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 20},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+		{Name: "Cid", Email: "cid@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	users[0].Age, users[1].Age, users[2].Age = 3, 1, 2
+	users[1].Name = "Bob?"
+	if err := repo.UpdateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to update batch: %v", err)
+	}
+	found, err := repo.FindAll(ctx, gpa.OrderBy("age", gpa.OrderAsc))
+	if err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if len(found) != 3 || found[0].Name != "Bob?" || found[1].Name != "Cid" || found[2].Name != "Ann" {
+		t.Errorf("Unexpected users after batch update: %+v", found)
+	}
+
+	// Rows outside the repository scopes are left alone
+	scoped := GetScopedRepository[TestUser](repo.provider, gpa.Where("name", gpa.OpEqual, "Ann")).(*Repository[TestUser])
+	for _, user := range users {
+		user.Age = 99
+	}
+	if err := scoped.UpdateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to update scoped batch: %v", err)
+	}
+	if count, _ := repo.Count(ctx, gpa.Where("age", gpa.OpEqual, 99)); count != 1 {
+		t.Errorf("Expected only the scoped row to be updated, got %d", count)
+	}
+
+	// MySQL assigns each column with a CASE over the primary keys; the
+	// SQLite connection can't run it, but the error carries the SQL
+	err = newDialectRepository(t, mysqldialect.New()).UpdateBatch(ctx, []*TestUser{
+		{ID: 1, Name: "Ann", Age: 3},
+		{ID: 2, Name: "Bob?", Age: 1},
+	})
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	want := "UPDATE `test_users` AS `test_user` SET " +
+		"`name` = CASE WHEN `id` = 1 THEN 'Ann' WHEN `id` = 2 THEN 'Bob?' END, " +
+		"`email` = CASE WHEN `id` = 1 THEN '' WHEN `id` = 2 THEN '' END, " +
+		"`age` = CASE WHEN `id` = 1 THEN 3 WHEN `id` = 2 THEN 1 END " +
+		"WHERE ((`id` = 1) OR (`id` = 2))"
+	if queryErr.SQL() != want {
+		t.Errorf("Expected %s, got %s", want, queryErr.SQL())
+	}
+}
+
+// TestContact stores an empty nickname as NULL
+type TestContact struct {
+	bun.BaseModel `bun:"table:test_contacts"`
+
+	ID       int64  `bun:",pk,autoincrement"`
+	Nickname string `bun:"nickname,nullzero"`
+}
+
+func TestRepositoryUpdatePartialNullZero(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestContact)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	contacts := GetRepository[TestContact](repo.provider).(*Repository[TestContact])
+
+	nickname := func(id int64) sql.NullString {
+		t.Helper()
+		var value sql.NullString
+		if err := repo.db.NewSelect().Table("test_contacts").Column("nickname").Where("id = ?", id).Scan(ctx, &value); err != nil {
+			t.Fatalf("Failed to read nickname: %v", err)
+		}
+		return value
+	}
+
+	contact := &TestContact{}
+	if err := contacts.Create(ctx, contact); err != nil {
+		t.Fatalf("Failed to create contact: %v", err)
+	}
+	if value := nickname(contact.ID); value.Valid {
+		t.Errorf("Expected an empty nickname to be inserted as NULL, got %q", value.String)
+	}
+
+	if err := contacts.UpdatePartial(ctx, contact.ID, map[string]interface{}{"nickname": "Bo"}); err != nil {
+		t.Fatalf("Failed to update partial: %v", err)
+	}
+	if err := contacts.UpdatePartial(ctx, contact.ID, map[string]interface{}{"nickname": ""}); err != nil {
+		t.Fatalf("Failed to update partial: %v", err)
+	}
+	if value := nickname(contact.ID); value.Valid {
+		t.Errorf("Expected an empty nickname to be written as NULL, got %q", value.String)
+	}
+
+	// An expression stores an actual empty string
+	if err := contacts.UpdatePartial(ctx, contact.ID, map[string]interface{}{"nickname": Expr("''")}); err != nil {
+		t.Fatalf("Failed to update partial: %v", err)
+	}
+	if value := nickname(contact.ID); !value.Valid || value.String != "" {
+		t.Errorf("Expected an empty string, got %v", value)
+	}
+}
+
+func TestRepositoryExplainQuery(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	query, args, err := repo.ExplainQuery(
+		gpa.Where("age", gpa.OpGreaterThan, 21),
+		gpa.OrderBy("name", gpa.OrderDesc),
+		gpa.Limit(10),
+	)
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if len(args) != 0 {
+		t.Errorf("Expected interpolated args, got %v", args)
+	}
+
+	expected := `SELECT "test_user"."id", "test_user"."name", "test_user"."email", "test_user"."age" FROM "test_users" AS "test_user" WHERE ("age" > 21) ORDER BY "name" DESC LIMIT 10`
+	if query != expected {
+		t.Errorf("Expected SQL:\n%s\ngot:\n%s", expected, query)
+	}
+}
+
+func TestRepositoryExplain(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	plan, err := repo.Explain(ctx, false, gpa.Where("age", gpa.OpGreaterThan, 21))
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.Contains(plan, "SCAN") {
+		t.Errorf("Expected a table scan in the plan, got %q", plan)
+	}
+
+	_, err = repo.Explain(ctx, true)
+	if !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error for analyze on sqlite, got %v", err)
+	}
+
+	// The SQLite connection can't run the Postgres EXPLAIN; the error carries it
+	_, err = newDialectRepository(t, pgdialect.New()).Explain(ctx, false)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || !strings.HasPrefix(queryErr.SQL(), "EXPLAIN (FORMAT JSON) SELECT") {
+		t.Errorf("Expected a QueryError carrying the EXPLAIN, got %v", err)
+	}
+}
+
+func TestRepositoryBuild(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, user := range []*TestUser{
+		{Name: "Alice", Email: "alice@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 17},
+		{Name: "Carol", Email: "carol@example.com", Age: 45},
+	} {
+		if err := repo.Create(ctx, user); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	query := repo.Build(
+		gpa.Where("age", gpa.OpGreaterThan, 21),
+		gpa.Where("name", gpa.OpNotEqual, "who?"),
+		gpa.OrderBy("name", gpa.OrderAsc),
+	)
+	if err := query.Err(); err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	expected := `SELECT "test_user"."id", "test_user"."name", "test_user"."email", "test_user"."age" FROM "test_users" AS "test_user" WHERE ("age" > 21) AND ("name" != 'who?') ORDER BY "name" ASC`
+	if query.SQL() != expected {
+		t.Errorf("Expected SQL:\n%s\ngot:\n%s", expected, query.SQL())
+	}
+
+	// The query can be run more than once
+	for i := 0; i < 2; i++ {
+		var users []*TestUser
+		if err := query.Scan(ctx, &users); err != nil {
+			t.Fatalf("Failed to scan query: %v", err)
+		}
+		if len(users) != 2 || users[0].Name != "Alice" || users[1].Name != "Carol" {
+			t.Errorf("Expected Alice and Carol, got %v", users)
+		}
+	}
+
+	errScope := errors.New("no tenant")
+	scoped := GetScopedRepository[TestUser](repo.provider, ContextScope(func(ctx context.Context) (gpa.Condition, error) {
+		return nil, errScope
+	})).(*Repository[TestUser])
+	failed := scoped.Build()
+	if !errors.Is(failed.Err(), errScope) || failed.SQL() != "" {
+		t.Errorf("Expected the scope error, got %v", failed.Err())
+	}
+	var users []*TestUser
+	if err := failed.Scan(ctx, &users); !errors.Is(err, errScope) {
+		t.Errorf("Expected the scope error from Scan, got %v", err)
+	}
+}
+
+type TestTask struct {
+	ID    int64  `bun:",pk,autoincrement"`
+	Order int    `bun:"order"`
+	Group string `bun:"group"`
+}
+
+func TestRepositoryReservedWordColumns(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	_, err = provider.db.NewCreateTable().Model((*TestTask)(nil)).Exec(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+
+	repo := &Repository[TestTask]{db: provider.db, provider: provider}
+	tasks := []*TestTask{
+		{Order: 2, Group: "a"},
+		{Order: 1, Group: "a"},
+		{Order: 3, Group: "b"},
+	}
+	if err := repo.CreateBatch(ctx, tasks); err != nil {
+		t.Fatalf("Failed to create tasks: %v", err)
+	}
+
+	found, err := repo.FindAll(ctx,
+		gpa.Where("group", gpa.OpEqual, "a"),
+		gpa.OrderBy("order", gpa.OrderAsc),
+	)
+	if err != nil {
+		t.Fatalf("Failed to query reserved word columns: %v", err)
+	}
+	if len(found) != 2 || found[0].Order != 1 {
+		t.Errorf("Expected group 'a' ordered by 'order', got %v", found)
+	}
+
+	found, err = repo.FindAll(ctx, gpa.Select("group"), gpa.GroupBy("group"), gpa.OrderBy("group", gpa.OrderAsc))
+	if err != nil {
+		t.Fatalf("Failed to group by reserved word column: %v", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("Expected 2 groups, got %d", len(found))
+	}
+
+	if err := repo.DeleteByCondition(ctx, gpa.WhereCondition("order", gpa.OpGreaterThan, 2)); err != nil {
+		t.Fatalf("Failed to delete by reserved word column: %v", err)
+	}
+	if count, _ := repo.Count(ctx); count != 2 {
+		t.Errorf("Expected 2 remaining tasks, got %d", count)
+	}
+}
+
+func TestRepositoryQueryMaps(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	users := []*TestUser{
+		{Name: "Alice", Email: "alice@example.com", Age: 25},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	rows, err := repo.QueryMaps(ctx, gpa.Select("id", "name"), gpa.OrderBy("name", gpa.OrderAsc))
+	if err != nil {
+		t.Fatalf("Failed to query maps: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	if len(rows[0]) != 2 {
+		t.Errorf("Expected only the selected columns, got %v", rows[0])
+	}
+	if rows[0]["name"] != "Alice" {
+		t.Errorf("Expected 'Alice' first, got %v", rows[0]["name"])
+	}
+	if _, ok := rows[0]["id"]; !ok {
+		t.Error("Expected id column in result")
+	}
+}
+
+func TestRepositoryStatementTimeout(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := &TestUser{Name: "John Doe", Email: "john@example.com", Age: 30}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// SQLite has no server-side timeout, so the option must not affect results
+	found, err := repo.FindAll(ctx, StatementTimeout(time.Second), gpa.Where("age", gpa.OpEqual, 30))
+	if err != nil {
+		t.Fatalf("Failed to query with statement timeout: %v", err)
+	}
+	if len(found) != 1 {
+		t.Errorf("Expected 1 user, got %d", len(found))
+	}
+
+	count, err := repo.Count(ctx, StatementTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to count with statement timeout: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected count 1, got %d", count)
+	}
+}
+
+func TestRepositoryWithTimeout(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{Name: "John Doe", Email: "john@example.com", Age: 30}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// A default too short for any query expires before it runs
+	repo.provider.queryTimeout = time.Nanosecond
+	if _, err := repo.FindAll(ctx); !gpa.IsErrorType(err, gpa.ErrorTypeTimeout) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if _, err := repo.FindByID(ctx, 1); !gpa.IsErrorType(err, gpa.ErrorTypeTimeout) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+
+	// WithTimeout replaces the default for one operation
+	found, err := repo.FindAll(ctx, WithTimeout(time.Minute))
+	if err != nil || len(found) != 1 {
+		t.Errorf("Expected the report to outlast the default, got %d, %v", len(found), err)
+	}
+
+	repo.provider.queryTimeout = 0
+	if _, err := repo.Count(ctx, WithTimeout(time.Nanosecond)); !gpa.IsErrorType(err, gpa.ErrorTypeTimeout) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+}
+
+func TestRepositoryOrderByExpr(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.CreateBatch(ctx, []*TestUser{
+		{Name: "alice", Email: "alice@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 20},
+		{Name: "Cid", Email: "cid@example.com", Age: 30},
+		{Name: "dan", Email: "dan@example.com", Age: 40},
+	}); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	names := func(users []*TestUser) string {
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// Priority first, then case-insensitively by name
+	found, err := repo.FindAll(ctx, OrderByExpr("CASE WHEN name = ? THEN 0 ELSE 1 END, lower(name) DESC", "Cid"))
+	if err != nil {
+		t.Fatalf("Failed to order by expression: %v", err)
+	}
+	if got := names(found); got != "Cid,dan,Bob,alice" {
+		t.Errorf("Unexpected order: %s", got)
+	}
+
+	// Expressions follow column orderings, and replace the default order
+	ordered := repo.OrderedBy(gpa.Order{Field: "name", Direction: gpa.OrderAsc})
+	found, err = ordered.FindAll(ctx, gpa.OrderBy("age", gpa.OrderDesc), OrderByExpr("lower(name)"))
+	if err != nil {
+		t.Fatalf("Failed to order by expression: %v", err)
+	}
+	if got := names(found); got != "dan,alice,Cid,Bob" {
+		t.Errorf("Unexpected order: %s", got)
+	}
+	found, err = ordered.FindAll(ctx, OrderByExpr("age DESC, lower(name) DESC"))
+	if err != nil {
+		t.Fatalf("Failed to order by expression: %v", err)
+	}
+	if got := names(found); got != "dan,Cid,alice,Bob" {
+		t.Errorf("Expected the expression to replace the default order, got %s", got)
+	}
+}
+
+func TestRepositoryLastSQL(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.FindAll(ctx); err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if sql, _ := repo.LastSQL(); sql != "" {
+		t.Errorf("Expected no query recorded outside debug mode, got %s", sql)
+	}
+
+	provider, err := NewProvider(gpa.Config{
+		Driver:   "sqlite3",
+		Database: ":memory:",
+		Options: map[string]interface{}{
+			"bun": map[string]interface{}{"debug": true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+	if _, err := provider.db.NewCreateTable().Model((*TestUser)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	users := GetRepository[TestUser](provider).(*Repository[TestUser])
+	other := GetRepository[TestUser](provider).(*Repository[TestUser])
+
+	if err := users.Create(ctx, &TestUser{Name: "Ann", Email: "ann@example.com"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := users.FindAll(ctx, gpa.Where("name", gpa.OpEqual, "Ann")); err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	sql, args := users.LastSQL()
+	want := `SELECT "test_user"."id", "test_user"."name", "test_user"."email", "test_user"."age" FROM "test_users" AS "test_user" WHERE ("name" = 'Ann')`
+	if sql != want || args != nil {
+		t.Errorf("Expected %s, got %s %v", want, sql, args)
+	}
+	if sql, _ := other.LastSQL(); sql != "" {
+		t.Errorf("Expected another repository to record its own queries, got %s", sql)
+	}
+
+	// Failed queries are recorded too
+	if _, err := users.FindAll(ctx, WhereRaw("missing = 1")); err == nil {
+		t.Fatal("Expected an error for an unknown column")
+	}
+	if sql, _ := users.LastSQL(); !strings.HasSuffix(sql, "WHERE (missing = 1)") {
+		t.Errorf("Expected the failed query, got %s", sql)
+	}
+
+	if _, err := users.FastInsert(ctx, []*TestUser{{Name: "Bob"}}); err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	if sql, _ := users.LastSQL(); sql != `INSERT INTO "test_users" ("name", "email", "age") VALUES ('Bob', '', 0)` {
+		t.Errorf("Expected the FastInsert statement, got %s", sql)
+	}
+}
+
+func TestRepositoryStream(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := make([]*TestUser, 10)
+	for i := range users {
+		users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Age: i}
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	entities, errs := repo.Stream(ctx, gpa.Where("age", gpa.OpGreaterThanOrEqual, 5), gpa.OrderBy("age", gpa.OrderAsc))
+	var ages []int
+	for entity := range entities {
+		ages = append(ages, entity.Age)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Failed to stream users: %v", err)
+	}
+	if fmt.Sprint(ages) != "[5 6 7 8 9]" {
+		t.Errorf("Unexpected streamed ages: %v", ages)
+	}
+
+	// Cancelling stops the query early
+	streamCtx, cancel := context.WithCancel(ctx)
+	entities, errs = repo.Stream(streamCtx)
+	<-entities
+	cancel()
+	for range entities {
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation, got %v", err)
+	}
+	// The connection is released for other operations
+	if count, err := repo.Count(ctx); err != nil || count != 10 {
+		t.Errorf("Expected 10 users, got %d, %v", count, err)
+	}
+
+	entities, errs = repo.Stream(ctx, WhereRaw("missing = 1"))
+	if _, ok := <-entities; ok {
+		t.Error("Expected no entities from a failing query")
+	}
+	var queryErr *QueryError
+	if err := <-errs; !errors.As(err, &queryErr) {
+		t.Errorf("Expected QueryError, got %v", err)
+	}
+}
+
+func TestRepositoryWhereRaw(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	users := []*TestUser{
+		{Name: "Alice", Email: "Alice@Example.com", Age: 25},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	found, err := repo.FindAll(ctx,
+		WhereRaw("lower(email) = lower(?)", "alice@example.com"),
+		gpa.Where("age", gpa.OpLessThan, 30),
+	)
+	if err != nil {
+		t.Fatalf("Failed to query with raw condition: %v", err)
+	}
+	if len(found) != 1 || found[0].Name != "Alice" {
+		t.Errorf("Expected only 'Alice', got %v", found)
+	}
+
+	count, err := repo.Count(ctx, WhereRaw("age BETWEEN ? AND ?", 20, 40))
+	if err != nil {
+		t.Fatalf("Failed to count with raw condition: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected count 2, got %d", count)
+	}
+}
+
+func newDialectRepository(t *testing.T, dialect schema.Dialect) *Repository[TestUser] {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return &Repository[TestUser]{db: bun.NewDB(sqlDB, dialect)}
+}
+
+func TestRepositoryLockingReads(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	user := &TestUser{Name: "John Doe", Email: "john@example.com", Age: 30}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// SQLite has no row locking, so the option is ignored
+	query, _, err := repo.ExplainQuery(ForUpdate())
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if strings.Contains(query, "FOR UPDATE") {
+		t.Errorf("Expected no lock clause on sqlite, got %s", query)
+	}
+
+	err = repo.Transaction(ctx, func(tx gpa.Transaction[TestUser]) error {
+		found, err := tx.FindAll(ctx, ForUpdate())
+		if err != nil {
+			return err
+		}
+		if len(found) != 1 {
+			t.Errorf("Expected 1 user, got %d", len(found))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to run locking read: %v", err)
+	}
+
+	pgRepo := newDialectRepository(t, pgdialect.New())
+	query, _, err = pgRepo.ExplainQuery(gpa.Where("id", gpa.OpEqual, 1), ForUpdate())
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, " FOR UPDATE") {
+		t.Errorf("Expected FOR UPDATE clause, got %s", query)
+	}
+
+	query, _, err = pgRepo.ExplainQuery(ForShare())
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, " FOR SHARE") {
+		t.Errorf("Expected FOR SHARE clause, got %s", query)
+	}
+}
+
+func TestRepositoryLockModifiers(t *testing.T) {
+	pgRepo := newDialectRepository(t, pgdialect.New())
+
+	tests := []struct {
+		option   gpa.QueryOption
+		expected string
+	}{
+		{ForUpdate(SkipLocked), " FOR UPDATE SKIP LOCKED"},
+		{ForUpdate(NoWait), " FOR UPDATE NOWAIT"},
+		{ForShare(SkipLocked), " FOR SHARE SKIP LOCKED"},
+		{ForShare(NoWait), " FOR SHARE NOWAIT"},
+	}
+	for _, tt := range tests {
+		query, _, err := pgRepo.ExplainQuery(gpa.Limit(1), tt.option)
+		if err != nil {
+			t.Fatalf("Failed to explain query: %v", err)
+		}
+		if !strings.HasSuffix(query, tt.expected) {
+			t.Errorf("Expected suffix %q, got %s", tt.expected, query)
+		}
+	}
+
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	_, err := repo.FindAll(context.Background(), ForUpdate(SkipLocked))
+	if !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error for SKIP LOCKED on sqlite, got %v", err)
+	}
+}
+
+type TestPost struct {
+	ID     int64  `bun:",pk,autoincrement"`
+	UserID int64  `bun:"user_id"`
+	Title  string `bun:"title"`
+}
+
+type TestPostWithAuthor struct {
+	TestPost   `bun:",extend"`
+	AuthorName string `bun:"author_name"`
+}
+
+func TestQueryAs(t *testing.T) {
+	userRepo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := userRepo.db.NewCreateTable().Model((*TestPost)(nil)).Exec(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	postRepo := &Repository[TestPost]{db: userRepo.db, provider: userRepo.provider}
+
+	author := &TestUser{Name: "Alice", Email: "alice@example.com", Age: 30}
+	if err := userRepo.Create(ctx, author); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := postRepo.Create(ctx, &TestPost{UserID: author.ID, Title: "Hello"}); err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+
+	posts, err := QueryAs[TestPostWithAuthor](ctx, postRepo,
+		gpa.InnerJoin("test_users AS u", "u.id = test_post.user_id"),
+		SelectAs("u.name", "author_name"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to query with join: %v", err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("Expected 1 post, got %d", len(posts))
+	}
+	if posts[0].Title != "Hello" || posts[0].AuthorName != "Alice" {
+		t.Errorf("Expected post 'Hello' by 'Alice', got %+v", posts[0])
+	}
+}
+
+type TestNullableUser struct {
+	bun.BaseModel `bun:"table:test_nullable_users"`
+
+	ID   int64   `bun:",pk,autoincrement"`
+	Name string  `bun:"name"`
+	Rank *int    `bun:"rank"`
+	Note *string `bun:"note"`
+}
+
+func TestRepositoryOrderByNulls(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	_, err = provider.db.NewCreateTable().Model((*TestNullableUser)(nil)).Exec(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+
+	repo := &Repository[TestNullableUser]{db: provider.db, provider: provider}
+	one, two := 1, 2
+	users := []*TestNullableUser{{Name: "b", Rank: &two}, {Name: "none"}, {Name: "a", Rank: &one}}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	found, err := repo.FindAll(ctx, OrderByNulls("rank", gpa.OrderAsc, NullsLast))
+	if err != nil {
+		t.Fatalf("Failed to order with nulls last: %v", err)
+	}
+	if len(found) != 3 || found[0].Name != "a" || found[2].Name != "none" {
+		t.Errorf("Expected NULL rank last, got %v", found)
+	}
+
+	found, err = repo.FindAll(ctx, OrderByNulls("rank", gpa.OrderDesc, NullsFirst))
+	if err != nil {
+		t.Fatalf("Failed to order with nulls first: %v", err)
+	}
+	if len(found) != 3 || found[0].Name != "none" || found[1].Name != "b" {
+		t.Errorf("Expected NULL rank first, got %v", found)
+	}
+
+	mysqlRepo := newDialectRepository(t, mysqldialect.New())
+	query, _, err := mysqlRepo.ExplainQuery(OrderByNulls("age", gpa.OrderAsc, NullsLast))
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, "ORDER BY `age` IS NULL ASC, `age` ASC") {
+		t.Errorf("Expected emulated NULLS LAST on mysql, got %s", query)
+	}
+}
+
+type TestArticle struct {
+	ID   int64    `bun:",pk,autoincrement"`
+	Tags []string `bun:"tags,array"`
+}
+
+func TestArrayConditions(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer sqlDB.Close()
+
+	repo := &Repository[TestArticle]{db: bun.NewDB(sqlDB, pgdialect.New())}
+
+	query, _, err := repo.ExplainQuery(ArrayContains("tags", []string{"go", "sql"}))
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, `WHERE ("tags" @> '{"go","sql"}')`) {
+		t.Errorf("Expected array containment, got %s", query)
+	}
+
+	query, _, err = repo.ExplainQuery(ArrayContains("tags", "go"))
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, `WHERE ("tags" @> '{"go"}')`) {
+		t.Errorf("Expected single value to be wrapped in an array, got %s", query)
+	}
+
+	query, _, err = repo.ExplainQuery(ArrayContains("tags", nil))
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, `WHERE (1 = 0)`) {
+		t.Errorf("Expected nil values to match no rows, got %s", query)
+	}
+
+	query, _, err = repo.ExplainQuery(ArrayAny("tags", "go"))
+	if err != nil {
+		t.Fatalf("Failed to explain query: %v", err)
+	}
+	if !strings.HasSuffix(query, `WHERE ('go' = ANY("tags"))`) {
+		t.Errorf("Expected ANY condition, got %s", query)
+	}
+
+	b, err := repo.db.NewInsert().Model(&TestArticle{Tags: []string{"go", "sql"}}).AppendQuery(schema.NewFormatter(repo.db.Dialect()), nil)
+	if err != nil {
+		t.Fatalf("Failed to render insert: %v", err)
+	}
+	if !strings.Contains(string(b), `'{"go","sql"}'`) {
+		t.Errorf("Expected array to bind as a Postgres array literal, got %s", b)
+	}
+}
+
+func TestRepositoryCreateIgnore(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	user := &TestUser{Name: "Ann", Email: "ann@example.com", Age: 30}
+	inserted, err := repo.CreateIgnore(ctx, user, []string{"id"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if !inserted || user.ID == 0 {
+		t.Fatalf("Expected user to be inserted, got inserted=%v id=%d", inserted, user.ID)
+	}
+
+	duplicate := &TestUser{ID: user.ID, Name: "Bob", Email: "bob@example.com", Age: 40}
+	inserted, err = repo.CreateIgnore(ctx, duplicate, []string{"id"})
+	if err != nil {
+		t.Fatalf("Expected conflict to be ignored, got %v", err)
+	}
+	if inserted {
+		t.Error("Expected conflicting user not to be inserted")
+	}
+
+	// Without conflict columns any conflict is ignored
+	inserted, err = repo.CreateIgnore(ctx, duplicate, nil)
+	if err != nil || inserted {
+		t.Errorf("Expected conflict to be ignored, got inserted=%v err=%v", inserted, err)
+	}
+
+	found, err := repo.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if found.Name != "Ann" {
+		t.Errorf("Expected existing row to be kept, got %s", found.Name)
+	}
+}
+
+func TestScopedRepository(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+		{Name: "Cid", Email: "cid@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	scoped := GetScopedRepository[TestUser](repo.provider, gpa.Where("age", gpa.OpEqual, 30))
+
+	found, err := scoped.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if len(found) != 2 {
+		t.Errorf("Expected 2 scoped users, got %d", len(found))
+	}
+
+	count, err := scoped.Count(ctx, gpa.Where("name", gpa.OpEqual, "Cid"))
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected out-of-scope user not to be counted, got %d", count)
+	}
+
+	if _, err := scoped.FindByID(ctx, users[2].ID); !gpa.IsNotFound(err) {
+		t.Errorf("Expected out-of-scope user not to be found, got %v", err)
+	}
+	if err := scoped.Delete(ctx, users[2].ID); !gpa.IsNotFound(err) {
+		t.Errorf("Expected out-of-scope delete to fail with not found, got %v", err)
+	}
+	if err := scoped.UpdatePartial(ctx, users[2].ID, map[string]interface{}{"name": "Changed"}); !gpa.IsNotFound(err) {
+		t.Errorf("Expected out-of-scope update to fail with not found, got %v", err)
+	}
+
+	err = scoped.Transaction(ctx, func(tx gpa.Transaction[TestUser]) error {
+		return tx.DeleteByCondition(ctx, gpa.BasicCondition{FieldName: "name", Op: gpa.OpNotEqual, Val: ""})
+	})
+	if err != nil {
+		t.Fatalf("Failed to delete users: %v", err)
+	}
+
+	remaining, err := repo.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].Name != "Cid" {
+		t.Errorf("Expected only the unchanged out-of-scope user to remain, got %+v", remaining)
+	}
+}
+
+type tenantKey struct{}
+
+func TestContextScopedRepository(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 30},
+		{Name: "Cid", Email: "cid@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	scoped := GetScopedRepository[TestUser](repo.provider, ContextScope(func(ctx context.Context) (gpa.Condition, error) {
+		age, ok := ctx.Value(tenantKey{}).(int)
+		if !ok {
+			return nil, gpa.GPAError{Type: gpa.ErrorTypeInvalidArgument, Message: "missing tenant"}
+		}
+		return gpa.BasicCondition{FieldName: "age", Op: gpa.OpEqual, Val: age}, nil
+	}))
+
+	for age, want := range map[int]int64{30: 2, 40: 1, 50: 0} {
+		count, err := scoped.Count(context.WithValue(ctx, tenantKey{}, age))
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		if count != want {
+			t.Errorf("Expected %d users for tenant %d, got %d", want, age, count)
+		}
+	}
+
+	if _, err := scoped.FindAll(ctx); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected missing tenant to fail the query, got %v", err)
+	}
+
+	tenantCtx := context.WithValue(ctx, tenantKey{}, 40)
+	if _, err := scoped.FindByID(tenantCtx, users[0].ID); !gpa.IsNotFound(err) {
+		t.Errorf("Expected other tenant's user not to be found, got %v", err)
+	}
+	if err := scoped.Delete(tenantCtx, users[2].ID); err != nil {
+		t.Errorf("Failed to delete tenant's user: %v", err)
+	}
+}
+
+type TestAccount struct {
+	bun.BaseModel `bun:"table:test_accounts"`
+
+	ID         int64        `bun:",pk,autoincrement"`
+	Name       string       `bun:"name"`
+	DeletedAt  time.Time    `bun:"deleted_at,nullzero"`
+	LastLogin  bun.NullTime `bun:"last_login"`
+	VerifiedAt sql.NullTime `bun:"verified_at"`
+}
+
+func TestRepositoryNullableTimes(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	_, err = provider.db.NewCreateTable().Model((*TestAccount)(nil)).Exec(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := &Repository[TestAccount]{db: provider.db, provider: provider}
+
+	nulls := func() int64 {
+		t.Helper()
+		count, err := repo.Count(ctx, WhereRaw("deleted_at IS NULL AND last_login IS NULL AND verified_at IS NULL"))
+		if err != nil {
+			t.Fatalf("Failed to count accounts: %v", err)
+		}
+		return count
+	}
+
+	// Zero values are written as NULL and NULLs scan back as zero values
+	account := &TestAccount{Name: "ann"}
+	if err := repo.Create(ctx, account); err != nil {
+		t.Fatalf("Failed to create account: %v", err)
+	}
+	if nulls() != 1 {
+		t.Fatal("Expected zero times to be stored as NULL")
+	}
+	found, err := repo.FindByID(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("Failed to find account: %v", err)
+	}
+	if !found.DeletedAt.IsZero() || !found.LastLogin.IsZero() || found.VerifiedAt.Valid {
+		t.Errorf("Expected NULL times to scan as zero values, got %+v", found)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	found.DeletedAt = now
+	found.LastLogin = bun.NullTime{Time: now}
+	found.VerifiedAt = sql.NullTime{Time: now, Valid: true}
+	if err := repo.Update(ctx, found); err != nil {
+		t.Fatalf("Failed to update account: %v", err)
+	}
+	found, err = repo.FindByID(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("Failed to find account: %v", err)
+	}
+	if !found.DeletedAt.Equal(now) || !found.LastLogin.Equal(now) || !found.VerifiedAt.Time.Equal(now) {
+		t.Errorf("Expected times to round-trip, got %+v", found)
+	}
+
+	// Resetting to zero values writes NULL again
+	found.DeletedAt = time.Time{}
+	found.LastLogin = bun.NullTime{}
+	found.VerifiedAt = sql.NullTime{}
+	if err := repo.Update(ctx, found); err != nil {
+		t.Fatalf("Failed to update account: %v", err)
+	}
+	if nulls() != 1 {
+		t.Error("Expected zero times to be updated to NULL")
+	}
+}
+
+func TestRepositoryUpdateColumns(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	user := &TestUser{Name: "Ann", Email: "ann@example.com", Age: 30}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// A projection leaves the unselected fields zero
+	partial, err := repo.QueryOne(ctx, gpa.Select("id", "name"), gpa.Where("id", gpa.OpEqual, user.ID))
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	partial.Name = "Ann Updated"
+	if err := repo.UpdateColumns(ctx, partial, "name"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	found, err := repo.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if found.Name != "Ann Updated" || found.Email != "ann@example.com" || found.Age != 30 {
+		t.Errorf("Expected only name to change, got %+v", found)
+	}
+
+	found.Email = "changed@example.com"
+	found.Age = 31
+	if err := repo.UpdateExcluding(ctx, found, "email"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	found, err = repo.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if found.Email != "ann@example.com" || found.Age != 31 {
+		t.Errorf("Expected email to be excluded from the update, got %+v", found)
+	}
+
+	if err := repo.UpdateColumns(ctx, found); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected error for empty column list, got %v", err)
+	}
+	if err := repo.UpdateColumns(ctx, found, "missing"); err == nil {
+		t.Error("Expected error for unknown column")
+	}
+}
+
+func TestRepositoryUpdateNonZero(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	user := &TestUser{Name: "Ann", Email: "ann@example.com", Age: 30}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if err := repo.UpdateNonZero(ctx, &TestUser{ID: user.ID, Name: "Ann Updated"}); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	found, err := repo.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if found.Name != "Ann Updated" || found.Email != "ann@example.com" || found.Age != 30 {
+		t.Errorf("Expected zero fields to be left unchanged, got %+v", found)
+	}
+
+	// IncludeZero forces a legitimately zero value to be written
+	if err := repo.UpdateNonZero(ctx, &TestUser{ID: user.ID}, IncludeZero("age")); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	found, err = repo.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if found.Age != 0 || found.Name != "Ann Updated" {
+		t.Errorf("Expected only age to be zeroed, got %+v", found)
+	}
+
+	if err := repo.UpdateNonZero(ctx, &TestUser{ID: user.ID}); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected error when no fields are set, got %v", err)
+	}
+}
+
+type TestProfile struct {
+	bun.BaseModel `bun:"table:test_profiles"`
+
+	ID        int64  `bun:",pk,autoincrement"`
+	Name      string `bun:"name"`
+	NameUpper string `bun:"name_upper,scanonly"`
+}
+
+func TestRepositoryUpdateReturning(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	_, err = provider.RawExec(ctx, `CREATE TABLE test_profiles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR,
+		name_upper VARCHAR GENERATED ALWAYS AS (upper(name))
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := &Repository[TestProfile]{db: provider.db, provider: provider}
+
+	profile := &TestProfile{Name: "ann"}
+	if err := repo.Create(ctx, profile); err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+
+	profile.Name = "bob"
+	if err := repo.UpdateReturning(ctx, profile); err != nil {
+		t.Fatalf("Failed to update profile: %v", err)
+	}
+	if profile.NameUpper != "BOB" {
+		t.Errorf("Expected computed column to be refreshed, got %q", profile.NameUpper)
+	}
+
+	missing := &TestProfile{ID: profile.ID + 100, Name: "nobody"}
+	if err := repo.UpdateReturning(ctx, missing); !gpa.IsNotFound(err) {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
+
+func TestAsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want UniqueViolation
+	}{
+		{
+			name: "postgres composite",
+			err: &pq.Error{
+				Code:       "23505",
+				Table:      "users",
+				Constraint: "uniq_tenant_email",
+				Detail:     "Key (tenant_id, email)=(1, ann@example.com) already exists.",
+			},
+			want: UniqueViolation{Name: "uniq_tenant_email", Table: "users", Columns: []string{"tenant_id", "email"}},
+		},
+		{
+			name: "postgres expression",
+			err: &pq.Error{
+				Code:       "23505",
+				Table:      "users",
+				Constraint: "users_email_idx",
+				Detail:     "Key (lower(email::text))=(ann@example.com) already exists.",
+			},
+			want: UniqueViolation{Name: "users_email_idx", Table: "users", Columns: []string{"lower(email::text)"}},
+		},
+		{
+			name: "mysql",
+			err:  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1-ann@example.com' for key 'users.uniq_tenant_email'"},
+			want: UniqueViolation{Name: "uniq_tenant_email", Table: "users"},
+		},
+		{
+			name: "mysql 5.7",
+			err:  &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'ann@example.com' for key 'email'"},
+			want: UniqueViolation{Name: "email"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := AsUniqueViolation(convertBunError(tt.err))
+			if !ok {
+				t.Fatal("Expected unique violation")
+			}
+			if got.Name != tt.want.Name || got.Table != tt.want.Table || strings.Join(got.Columns, ",") != strings.Join(tt.want.Columns, ",") {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+			if got.Composite() != (len(tt.want.Columns) > 1) {
+				t.Errorf("Expected Composite() to be %v", len(tt.want.Columns) > 1)
+			}
+		})
+	}
+
+	if _, ok := AsUniqueViolation(&pq.Error{Code: "23503"}); ok {
+		t.Error("Expected foreign key violation not to be a unique violation")
+	}
+}
+
+func TestAsCheckViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want CheckViolation
+	}{
+		{
+			name: "postgres",
+			err:  &pq.Error{Code: "23514", Table: "users", Constraint: "chk_age_nonnegative"},
+			want: CheckViolation{Name: "chk_age_nonnegative", Table: "users"},
+		},
+		{
+			name: "mysql",
+			err:  &mysql.MySQLError{Number: 3819, Message: "Check constraint 'chk_age_nonnegative' is violated."},
+			want: CheckViolation{Name: "chk_age_nonnegative"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertBunError(tt.err)
+			if !gpa.IsErrorType(err, gpa.ErrorTypeConstraint) || !strings.Contains(err.Error(), "chk_age_nonnegative") {
+				t.Errorf("Expected a constraint error naming the constraint, got %v", err)
+			}
+			got, ok := AsCheckViolation(err)
+			if !ok {
+				t.Fatal("Expected check violation")
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	if _, ok := AsCheckViolation(&pq.Error{Code: "23505"}); ok {
+		t.Error("Expected unique violation not to be a check violation")
+	}
+}
+
+type TestPerson struct {
+	bun.BaseModel `bun:"table:test_people"`
+
+	ID  int64 `bun:",pk,autoincrement"`
+	Age int   `bun:"age"`
+}
+
+func TestRepositoryCheckViolation(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.ExecContext(ctx, "CREATE TABLE test_people (id INTEGER PRIMARY KEY, age INTEGER CONSTRAINT chk_age_nonnegative CHECK (age >= 0))"); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestPerson](provider)
+
+	err = repo.Create(ctx, &TestPerson{Age: -1})
+	if !gpa.IsErrorType(err, gpa.ErrorTypeConstraint) {
+		t.Fatalf("Expected constraint error, got %v", err)
+	}
+	violation, ok := AsCheckViolation(err)
+	if !ok || violation.Name != "chk_age_nonnegative" {
+		t.Errorf("Expected chk_age_nonnegative to be violated, got %+v, %v", violation, err)
+	}
+	if _, ok := AsUniqueViolation(err); ok {
+		t.Error("Expected check violation not to be a unique violation")
+	}
+}
+
+type TestMembership struct {
+	bun.BaseModel `bun:"table:test_memberships"`
+
+	ID       int64  `bun:",pk,autoincrement"`
+	TenantID int64  `bun:"tenant_id,unique:uniq_tenant_email"`
+	Email    string `bun:"email,unique:uniq_tenant_email"`
+}
+
+func TestRepositoryCompositeUniqueViolation(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestMembership)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestMembership](provider)
+
+	if err := repo.Create(ctx, &TestMembership{TenantID: 1, Email: "ann@example.com"}); err != nil {
+		t.Fatalf("Failed to create membership: %v", err)
+	}
+	err = repo.Create(ctx, &TestMembership{TenantID: 1, Email: "ann@example.com"})
+	if !gpa.IsDuplicate(err) {
+		t.Fatalf("Expected duplicate error, got %v", err)
+	}
+
+	violation, ok := AsUniqueViolation(err)
+	if !ok {
+		t.Fatalf("Expected unique violation, got %v", err)
+	}
+	if !violation.Composite() || violation.Table != "test_memberships" || strings.Join(violation.Columns, ",") != "tenant_id,email" {
+		t.Errorf("Expected composite violation on tenant_id, email, got %+v", violation)
+	}
+}
+
+type countingCache struct {
+	Cache
+	hits          int
+	invalidations int
+}
+
+func (c *countingCache) Invalidate(ctx context.Context, table string) {
+	c.invalidations++
+	c.Cache.Invalidate(ctx, table)
+}
+
+func (c *countingCache) Get(ctx context.Context, table, key string) ([]byte, bool) {
+	value, ok := c.Cache.Get(ctx, table, key)
+	if ok {
+		c.hits++
+	}
+	return value, ok
+}
+
+func TestRepositoryCached(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	cache := &countingCache{Cache: NewMemoryCache()}
+	repo.provider.SetCache(cache)
+	cached := repo.Cached(time.Minute)
+
+	ctx := context.Background()
+	user := &TestUser{Name: "Ann", Email: "ann@example.com", Age: 30}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cached.FindByID(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to find user: %v", err)
+		}
+		if _, err := cached.FindAll(ctx, gpa.Where("age", gpa.OpEqual, 30)); err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+	}
+	if cache.hits != 2 {
+		t.Errorf("Expected repeated reads to hit the cache, got %d hits", cache.hits)
+	}
+
+	// A write through another repository of the table invalidates the results
+	user.Name = "Ann Updated"
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	found, err := cached.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if found.Name != "Ann Updated" {
+		t.Errorf("Expected fresh result after update, got %s", found.Name)
+	}
+	if cache.hits != 2 {
+		t.Errorf("Expected cache miss after invalidation, got %d hits", cache.hits)
+	}
+
+	users, err := cached.FindAll(ctx, gpa.Where("age", gpa.OpEqual, 30))
+	if err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if len(users) != 1 || users[0].Name != "Ann Updated" {
+		t.Errorf("Expected fresh results after update, got %+v", users)
+	}
+
+	// Uncached repositories don't read from the cache
+	if _, err := repo.FindByID(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+	if cache.hits != 2 {
+		t.Errorf("Expected uncached repository to bypass the cache, got %d hits", cache.hits)
+	}
+}
+
+func TestRepositoryCachedOtherTables(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	cache := &countingCache{Cache: NewMemoryCache()}
+	repo.provider.SetCache(cache)
+	cached := repo.Cached(time.Minute)
+
+	ctx := context.Background()
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestContact)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	contacts := GetRepository[TestContact](repo.provider)
+	if err := repo.Create(ctx, &TestUser{Name: "ann"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// Reads of test_contacts aren't invalidated by writes to it, so they
+	// must not be cached under test_users
+	for _, opts := range [][]gpa.QueryOption{
+		{gpa.InnerJoin("test_contacts AS c", "c.nickname = test_user.name")},
+		{WhereRaw("EXISTS (SELECT 1 FROM test_contacts AS c WHERE c.nickname = test_user.name)")},
+	} {
+		if err := contacts.DeleteByCondition(ctx, gpa.WhereCondition("id", gpa.OpGreaterThan, 0)); err != nil {
+			t.Fatalf("Failed to delete contacts: %v", err)
+		}
+		if users, err := cached.FindAll(ctx, opts...); err != nil || len(users) != 0 {
+			t.Fatalf("Expected no users without contacts, got %v, %v", users, err)
+		}
+		if err := contacts.Create(ctx, &TestContact{Nickname: "ann"}); err != nil {
+			t.Fatalf("Failed to create contact: %v", err)
+		}
+		if users, err := cached.FindAll(ctx, opts...); err != nil || len(users) != 1 {
+			t.Errorf("Expected the user with a contact, got %v, %v", users, err)
+		}
+	}
+	if cache.hits != 0 {
+		t.Errorf("Expected reads of other tables to bypass the cache, got %d hits", cache.hits)
+	}
+}
+
+func TestRepositoryCachedTenants(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	cache := &countingCache{Cache: NewMemoryCache()}
+	repo.provider.SetCache(cache)
+	type tenantKey struct{}
+	repo.provider.SetTenantSchema(func(ctx context.Context) (string, error) {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant, nil
+	})
+	cached := repo.Cached(time.Minute)
+
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{Name: "Ann"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// SQLite can't set a search_path, so scan without the session
+	scan := func(ctx context.Context) {
+		t.Helper()
+		var users []*TestUser
+		if err := cached.cachedScan(ctx, newSelect(cached.db, &users), &users, nil); err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+	}
+	tenantA := context.WithValue(ctx, tenantKey{}, "tenant_a")
+	scan(tenantA)
+	scan(tenantA)
+	if cache.hits != 1 {
+		t.Errorf("Expected the same tenant to hit the cache, got %d hits", cache.hits)
+	}
+	scan(context.WithValue(ctx, tenantKey{}, "tenant_b"))
+	if cache.hits != 1 {
+		t.Errorf("Expected another tenant to miss the cache, got %d hits", cache.hits)
+	}
+}
+
+func TestRepositoryCachedSessionVariables(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	cache := &countingCache{Cache: NewMemoryCache()}
+	repo.provider.SetCache(cache)
+	type userKey struct{}
+	repo.provider.SetSessionVariables(func(ctx context.Context) (map[string]string, error) {
+		user, _ := ctx.Value(userKey{}).(string)
+		return map[string]string{"app.user_id": user}, nil
+	})
+	cached := repo.Cached(time.Minute)
+
+	// SQLite can't set session variables, so scan without the session
+	ctx := context.Background()
+	scan := func(ctx context.Context) {
+		t.Helper()
+		var users []*TestUser
+		if err := cached.cachedScan(ctx, newSelect(cached.db, &users), &users, nil); err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+	}
+	scan(context.WithValue(ctx, userKey{}, "1"))
+	scan(context.WithValue(ctx, userKey{}, "2"))
+	if cache.hits != 0 {
+		t.Errorf("Expected another user to miss the cache, got %d hits", cache.hits)
+	}
+	scan(context.WithValue(ctx, userKey{}, "1"))
+	if cache.hits != 1 {
+		t.Errorf("Expected the same user to hit the cache, got %d hits", cache.hits)
+	}
+}
+
+func TestRepositoryCacheTransaction(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	cache := &countingCache{Cache: NewMemoryCache()}
+	repo.provider.SetCache(cache)
+
+	ctx := context.Background()
+	err := repo.Transaction(ctx, func(tx gpa.Transaction[TestUser]) error {
+		if err := tx.Create(ctx, &TestUser{Name: "Ann"}); err != nil {
+			return err
+		}
+		tx.(*Transaction[TestUser]).InvalidateOnCommit("test_notes")
+		if cache.invalidations != 0 {
+			t.Errorf("Expected no invalidation before the commit, got %d", cache.invalidations)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to run transaction: %v", err)
+	}
+	if cache.invalidations != 2 {
+		t.Errorf("Expected the written tables invalidated once committed, got %d invalidations", cache.invalidations)
+	}
+
+	// A rolled back transaction leaves the cached results valid
+	err = repo.Transaction(ctx, func(tx gpa.Transaction[TestUser]) error {
+		if err := tx.Create(ctx, &TestUser{Name: "Bob"}); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("Expected the transaction to fail")
+	}
+	if cache.invalidations != 2 {
+		t.Errorf("Expected no invalidation after a rollback, got %d invalidations", cache.invalidations)
+	}
+}
+
+func TestRepositoryCacheFailedBatch(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	cache := &countingCache{Cache: NewMemoryCache()}
+	repo.provider.SetCache(cache)
+
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{ID: 1, Name: "Ann"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	invalidations := cache.invalidations
+
+	// The conflicting batch rolls back, leaving the cached results valid
+	if err := repo.CreateBatch(ctx, []*TestUser{{ID: 2, Name: "Bob"}, {ID: 1, Name: "Ann"}}); err == nil {
+		t.Fatal("Expected CreateBatch to fail on a duplicate key")
+	}
+	if cache.invalidations != invalidations {
+		t.Errorf("Expected the failed batch not to invalidate, got %d invalidations", cache.invalidations-invalidations)
+	}
+	if err := repo.CreateBatch(ctx, []*TestUser{{ID: 2, Name: "Bob"}}); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	if cache.invalidations != invalidations+1 {
+		t.Errorf("Expected the batch to invalidate once, got %d invalidations", cache.invalidations-invalidations)
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache()
+	cache.Set(ctx, "users", "a", []byte("1"), time.Millisecond)
+	cache.Set(ctx, "users", "b", []byte("2"), time.Minute)
+
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "users", "a"); ok {
+		t.Error("Expected expired entry to be dropped")
+	}
+	if value, ok := cache.Get(ctx, "users", "b"); !ok || string(value) != "2" {
+		t.Errorf("Expected live entry, got %q", value)
+	}
+
+	cache.Invalidate(ctx, "users")
+	if _, ok := cache.Get(ctx, "users", "b"); ok {
+		t.Error("Expected invalidated entry to be dropped")
+	}
+}
+
+func TestLoader(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+		{Name: "Cid", Email: "cid@example.com", Age: 50},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	recorder := &recordingQueryHook{}
+	repo.provider.db.AddQueryHook(recorder)
+
+	loader, err := NewLoader(repo, 10*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+
+	ids := []interface{}{users[0].ID, users[2].ID, int(users[1].ID), users[0].ID, int64(999)}
+	found, errs := loader.LoadMany(ctx, ids)
+	if len(recorder.queries) != 1 || !strings.Contains(recorder.queries[0], " IN (") {
+		t.Fatalf("Expected one batched IN query, got %v", recorder.queries)
+	}
+	for i, want := range []string{"Ann", "Cid", "Bob", "Ann"} {
+		if errs[i] != nil || found[i] == nil || found[i].Name != want {
+			t.Errorf("Expected %s at %d, got %+v (%v)", want, i, found[i], errs[i])
+		}
+	}
+	if !gpa.IsNotFound(errs[4]) {
+		t.Errorf("Expected not found for missing id, got %v", errs[4])
+	}
+
+	// Loaded ids are memoized until cleared
+	if _, err := loader.Load(ctx, users[1].ID); err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	if len(recorder.queries) != 1 {
+		t.Errorf("Expected memoized load, got %d queries", len(recorder.queries))
+	}
+	loader.Clear(users[1].ID)
+	if _, err := loader.Load(ctx, users[1].ID); err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	if len(recorder.queries) != 2 {
+		t.Errorf("Expected cleared id to be fetched again, got %d queries", len(recorder.queries))
+	}
+
+	if _, err := NewLoader(&Repository[TestUserRole]{db: repo.db}, time.Millisecond, 0); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected composite primary key to be rejected, got %v", err)
+	}
+}
+
+func TestLoaderMaxBatch(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	// With a full batch the query doesn't wait for the window to end
+	loader, err := NewLoader(repo, time.Hour, 2)
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+	found, errs := loader.LoadMany(ctx, []interface{}{users[0].ID, users[1].ID})
+	if errs[0] != nil || errs[1] != nil || found[0].Name != "Ann" || found[1].Name != "Bob" {
+		t.Errorf("Expected both users, got %+v %v", found, errs)
+	}
+}
+
+func TestLoaderMaxParams(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{
+		Driver:   "sqlite3",
+		Database: ":memory:",
+		Options: map[string]interface{}{
+			"bun": map[string]interface{}{"max_bind_params": 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestUser)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	repo := GetRepository[TestUser](provider).(*Repository[TestUser])
+	users := []*TestUser{{Name: "Ann"}, {Name: "Bob"}, {Name: "Cid"}}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	recorder := &recordingQueryHook{}
+	provider.AddQueryHook(recorder)
+	loader, err := NewLoader(repo, 10*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("Failed to create loader: %v", err)
+	}
+	found, errs := loader.LoadMany(ctx, []interface{}{users[0].ID, users[1].ID, users[2].ID})
+	for i, want := range []string{"Ann", "Bob", "Cid"} {
+		if errs[i] != nil || found[i] == nil || found[i].Name != want {
+			t.Errorf("Expected %s at %d, got %+v (%v)", want, i, found[i], errs[i])
+		}
+	}
+	// An unbounded batch is split into IN lists of at most MaxParams ids
+	if len(recorder.queries) != 2 {
+		t.Errorf("Expected two IN queries, got %v", recorder.queries)
+	}
+}
+
+func TestRepositoryQueryJSON(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	empty, err := repo.QueryJSON(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query JSON: %v", err)
+	}
+	if string(empty) != "[]" {
+		t.Errorf("Expected empty array, got %s", empty)
+	}
+
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	data, err := repo.QueryJSON(ctx, gpa.Select("name", "age"), gpa.OrderBy("age", gpa.OrderDesc))
+	if err != nil {
+		t.Fatalf("Failed to query JSON: %v", err)
+	}
+	if string(data) != `[{"age":40,"name":"Bob"},{"age":30,"name":"Ann"}]` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+}
+
+func TestRepositoryQueryJSONPostgres(t *testing.T) {
+	repo := newDialectRepository(t, pgdialect.New())
+
+	// The SQLite connection can't run it, but the error carries the SQL
+	_, err := repo.QueryJSON(context.Background(), gpa.Where("age", gpa.OpGreaterThan, 18))
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	want := `SELECT coalesce(json_agg(t), '[]'::json) FROM (SELECT "test_user"."id", "test_user"."name", "test_user"."email", "test_user"."age" FROM "test_users" AS "test_user" WHERE ("age" > 18)) AS t`
+	if queryErr.SQL() != want {
+		t.Errorf("Expected %s, got %s", want, queryErr.SQL())
+	}
+}
+
+type TestDocument struct {
+	bun.BaseModel `bun:"table:test_documents"`
+
+	ID        int64     `bun:",pk,autoincrement"`
+	Title     string    `bun:"title"`
+	Archived  bool      `bun:"archived"`
+	DeletedAt time.Time `bun:"deleted_at,nullzero"`
+}
+
+func TestRepositoryDefaultScopes(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestDocument)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+
+	RegisterDefaultScope[TestDocument](provider, "not_deleted", RawCondition{Query: "deleted_at IS NULL"})
+	RegisterDefaultScope[TestDocument](provider, "active", gpa.BasicCondition{FieldName: "archived", Op: gpa.OpEqual, Val: false})
+
+	repo := GetRepository[TestDocument](provider)
+	docs := []*TestDocument{
+		{Title: "live"},
+		{Title: "archived", Archived: true},
+		{Title: "deleted", DeletedAt: time.Now()},
+	}
+	if err := repo.CreateBatch(ctx, docs); err != nil {
+		t.Fatalf("Failed to create documents: %v", err)
+	}
+
+	count := func(opts ...gpa.QueryOption) int64 {
+		t.Helper()
+		n, err := repo.Count(ctx, opts...)
+		if err != nil {
+			t.Fatalf("Failed to count documents: %v", err)
+		}
+		return n
+	}
+	if n := count(); n != 1 {
+		t.Errorf("Expected only the live document, got %d", n)
+	}
+	if n := count(Unscoped("active")); n != 2 {
+		t.Errorf("Expected archived documents to be included, got %d", n)
+	}
+	if n := count(Unscoped()); n != 3 {
+		t.Errorf("Expected all documents without default scopes, got %d", n)
+	}
+
+	if _, err := repo.FindByID(ctx, docs[1].ID); !gpa.IsNotFound(err) {
+		t.Errorf("Expected archived document to be hidden from FindByID, got %v", err)
+	}
+
+	// Writes are not restricted, so an archived document can be restored
+	if err := repo.UpdatePartial(ctx, docs[1].ID, map[string]interface{}{"archived": false}); err != nil {
+		t.Fatalf("Failed to restore document: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected restored document to be visible, got %d", n)
+	}
+}
+
+func TestPluck(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+		{Name: "Cid", Email: "cid@example.com", Age: 50},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	emails, err := Pluck[string](ctx, repo, "email", gpa.Where("age", gpa.OpGreaterThan, 35), gpa.OrderBy("age", gpa.OrderAsc))
+	if err != nil {
+		t.Fatalf("Failed to pluck emails: %v", err)
+	}
+	if strings.Join(emails, ",") != "bob@example.com,cid@example.com" {
+		t.Errorf("Unexpected emails: %v", emails)
+	}
+
+	ids, err := Pluck[int64](ctx, repo, "id", gpa.Select("name"))
+	if err != nil {
+		t.Fatalf("Failed to pluck ids: %v", err)
+	}
+	if len(ids) != 3 || ids[0] != users[0].ID {
+		t.Errorf("Unexpected ids: %v", ids)
+	}
+
+	none, err := Pluck[string](ctx, repo, "email", gpa.Where("age", gpa.OpGreaterThan, 100))
+	if err != nil {
+		t.Fatalf("Failed to pluck emails: %v", err)
+	}
+	if none == nil || len(none) != 0 {
+		t.Errorf("Expected empty slice, got %#v", none)
+	}
+}
+
+type TestUserRank struct {
+	TestUser `bun:",extend"`
+	AgeRank  int `bun:"age_rank"`
+}
+
+func TestWindow(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann1@example.com", Age: 30},
+		{Name: "Ann", Email: "ann2@example.com", Age: 50},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	ranked, err := QueryAs[TestUserRank](ctx, repo,
+		Window("ROW_NUMBER()", "age_rank", WindowSpec{
+			PartitionBy: []string{"name"},
+			OrderBy:     []gpa.Order{{Field: "age", Direction: gpa.OrderDesc}},
+		}),
+		gpa.OrderBy("email", gpa.OrderAsc),
+	)
+	if err != nil {
+		t.Fatalf("Failed to query ranks: %v", err)
+	}
+
+	ranks := map[string]int{}
+	for _, r := range ranked {
+		ranks[r.Email] = r.AgeRank
+	}
+	want := map[string]int{"ann1@example.com": 2, "ann2@example.com": 1, "bob@example.com": 1}
+	for email, rank := range want {
+		if ranks[email] != rank {
+			t.Errorf("Expected rank %d for %s, got %d", rank, email, ranks[email])
+		}
+	}
+}
+
+func TestRepositoryUnion(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 20},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+		{Name: "Cid", Email: "cid@example.com", Age: 60},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	queries := [][]gpa.QueryOption{
+		{gpa.Where("age", gpa.OpLessThan, 50)},
+		{gpa.Where("age", gpa.OpGreaterThan, 30), gpa.OrderBy("age", gpa.OrderDesc), gpa.Limit(1)},
+	}
+
+	all, err := repo.UnionAll(ctx, queries, gpa.OrderBy("age", gpa.OrderAsc))
+	if err != nil {
+		t.Fatalf("Failed to union all: %v", err)
+	}
+	var names []string
+	for _, u := range all {
+		names = append(names, u.Name)
+	}
+	if strings.Join(names, ",") != "Ann,Bob,Cid" {
+		t.Errorf("Unexpected union all result: %v", names)
+	}
+
+	queries[1] = []gpa.QueryOption{gpa.Where("age", gpa.OpGreaterThan, 30)}
+	all, err = repo.UnionAll(ctx, queries)
+	if err != nil {
+		t.Fatalf("Failed to union all: %v", err)
+	}
+	if len(all) != 4 {
+		t.Errorf("Expected 4 rows with duplicates, got %d", len(all))
+	}
+
+	distinct, err := repo.Union(ctx, queries, gpa.OrderBy("age", gpa.OrderDesc), gpa.Limit(2))
+	if err != nil {
+		t.Fatalf("Failed to union: %v", err)
+	}
+	if len(distinct) != 2 || distinct[0].Name != "Cid" || distinct[1].Name != "Bob" {
+		t.Errorf("Unexpected union result: %+v", distinct)
+	}
+
+	if _, err := repo.Union(ctx, nil); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument error, got %v", err)
+	}
+}
+
+func TestRepositoryBatchLimits(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	// TestUser has 4 columns, so 999 parameters fit 249 rows
+	if limits := repo.provider.BatchLimits(); limits.MaxParams != 999 {
+		t.Errorf("Expected the sqlite parameter limit, got %+v", limits)
+	}
+	if size := repo.BatchSize(); size != 249 {
+		t.Errorf("Expected a batch size of 249, got %d", size)
+	}
+	if size := newDialectRepository(t, pgdialect.New()).BatchSize(); size != 65535/4 {
+		t.Errorf("Expected the postgres batch size, got %d", size)
+	}
+
+	provider, err := NewProvider(gpa.Config{
+		Driver:   "sqlite3",
+		Database: ":memory:",
+		Options: map[string]interface{}{
+			"bun": map[string]interface{}{"max_bind_params": 12, "batch_size": float64(2)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestUser)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	limited := &Repository[TestUser]{db: provider.db, provider: provider}
+
+	if limits := provider.BatchLimits(); limits.MaxParams != 12 || limits.BatchSize != 2 {
+		t.Errorf("Expected the configured limits, got %+v", limits)
+	}
+	if size := limited.BatchSize(); size != 2 {
+		t.Errorf("Expected the configured batch size, got %d", size)
+	}
+
+	recorder := &recordingQueryHook{}
+	provider.AddQueryHook(recorder)
+	users := make([]*TestUser, 5)
+	ids := make([]interface{}, len(users))
+	for i := range users {
+		users[i] = &TestUser{ID: int64(i + 1), Name: fmt.Sprintf("user%d", i)}
+		ids[i] = users[i].ID
+	}
+	if err := limited.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create batch: %v", err)
+	}
+	inserts := 0
+	for _, query := range recorder.queries {
+		if strings.HasPrefix(query, "INSERT") {
+			inserts++
+		}
+	}
+	if inserts != 3 {
+		t.Errorf("Expected 3 chunked inserts, got %d", inserts)
+	}
+
+	found, err := limited.FindByIDs(ctx, ids)
+	if err != nil || len(found) != len(users) {
+		t.Errorf("Expected all users across IN chunks, got %d, %v", len(found), err)
+	}
+}
+
+func TestRepositoryCreateBatchAtomic(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	size := repo.BatchSize()
+	newUsers := func() []*TestUser {
+		users := make([]*TestUser, size+10)
+		for i := range users {
+			users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i)}
+		}
+		// The second chunk conflicts with the first on the primary key
+		users[0].ID = 1
+		users[size].ID = 1
+		return users
+	}
+
+	if err := repo.CreateBatch(ctx, newUsers()); err == nil {
+		t.Fatal("Expected duplicate key error")
+	}
+	count, err := repo.Count(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected the failed batch to be rolled back, got %d rows", count)
+	}
+
+	if err := repo.NonAtomic().CreateBatch(ctx, newUsers()); err == nil {
+		t.Fatal("Expected duplicate key error")
+	}
+	count, err = repo.Count(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != int64(size) {
+		t.Errorf("Expected the first chunk of %d rows to remain, got %d", size, count)
+	}
+}
+
+type TestNote struct {
+	bun.BaseModel `bun:"table:test_notes"`
+
+	ID        int64     `bun:",pk,autoincrement"`
+	Title     string    `bun:"title"`
+	DeletedAt time.Time `bun:",soft_delete,nullzero"`
+}
+
+func TestRepositoryDeleteAll(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 20},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+		{Name: "Cid", Email: "cid@example.com", Age: 60},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	if _, err := repo.DeleteAll(ctx); !gpa.IsValidation(err) {
+		t.Errorf("Expected validation error without conditions, got %v", err)
+	}
+
+	deleted, err := repo.DeleteAll(ctx, gpa.Where("age", gpa.OpGreaterThan, 30))
+	if err != nil {
+		t.Fatalf("Failed to delete users: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted rows, got %d", deleted)
+	}
+
+	scoped := GetScopedRepository[TestUser](repo.provider, gpa.Where("name", gpa.OpEqual, "Bob")).(*Repository[TestUser])
+	if deleted, err := scoped.DeleteAll(ctx, AllowUnconditional()); err != nil || deleted != 0 {
+		t.Errorf("Expected scoped delete to match no rows, got %d, %v", deleted, err)
+	}
+
+	deleted, err = repo.DeleteAll(ctx, AllowUnconditional())
+	if err != nil {
+		t.Fatalf("Failed to delete users: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted row, got %d", deleted)
+	}
+
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestNote)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	notes := GetRepository[TestNote](repo.provider).(*Repository[TestNote])
+	if err := notes.CreateBatch(ctx, []*TestNote{{Title: "a"}, {Title: "b"}}); err != nil {
+		t.Fatalf("Failed to create notes: %v", err)
+	}
+	deleted, err = notes.DeleteAll(ctx, gpa.Where("title", gpa.OpEqual, "a"))
+	if err != nil {
+		t.Fatalf("Failed to delete notes: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted note, got %d", deleted)
+	}
+	remaining, err := repo.provider.db.NewSelect().Model((*TestNote)(nil)).WhereAllWithDeleted().Count(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count notes: %v", err)
+	}
+	if remaining != 2 {
+		t.Errorf("Expected the note to be soft deleted, got %d rows", remaining)
+	}
+}
+
+func TestRepositoryDeleteReturning(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.CreateBatch(ctx, []*TestUser{
+		{Name: "Ann", Email: "ann@example.com", Age: 20},
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+		{Name: "Cid", Email: "cid@example.com", Age: 60},
+	}); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	if _, err := repo.DeleteReturning(ctx, nil); !gpa.IsValidation(err) {
+		t.Errorf("Expected validation error without a condition, got %v", err)
+	}
+
+	deleted, err := repo.DeleteReturning(ctx, gpa.WhereCondition("age", gpa.OpGreaterThan, 30))
+	if err != nil {
+		t.Fatalf("Failed to delete users: %v", err)
+	}
+	if len(deleted) != 2 || deleted[0].Email == "" || deleted[1].Email == "" {
+		t.Errorf("Expected the 2 deleted rows, got %+v", deleted)
+	}
+	if count, _ := repo.Count(ctx); count != 1 {
+		t.Errorf("Expected 1 remaining user, got %d", count)
+	}
+
+	deleted, err = repo.DeleteReturning(ctx, gpa.WhereCondition("age", gpa.OpGreaterThan, 100))
+	if err != nil || len(deleted) != 0 {
+		t.Errorf("Expected no deleted rows, got %+v, %v", deleted, err)
+	}
+
+	// Soft deletes return the rows with their deletion time
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestNote)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	notes := GetRepository[TestNote](repo.provider).(*Repository[TestNote])
+	if err := notes.CreateBatch(ctx, []*TestNote{{Title: "a"}, {Title: "b"}}); err != nil {
+		t.Fatalf("Failed to create notes: %v", err)
+	}
+	archived, err := notes.DeleteReturning(ctx, gpa.WhereCondition("title", gpa.OpEqual, "a"))
+	if err != nil {
+		t.Fatalf("Failed to delete notes: %v", err)
+	}
+	if len(archived) != 1 || archived[0].Title != "a" || archived[0].DeletedAt.IsZero() {
+		t.Errorf("Expected the soft deleted note, got %+v", archived)
+	}
+
+	// MySQL locks the rows before deleting them; the SQLite connection
+	// can't run it, but the error carries the SQL
+	_, err = newDialectRepository(t, mysqldialect.New()).DeleteReturning(ctx, gpa.WhereCondition("age", gpa.OpGreaterThan, 30))
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	want := "SELECT `test_user`.`id`, `test_user`.`name`, `test_user`.`email`, `test_user`.`age` FROM `test_users` AS `test_user` WHERE (1 = 1) AND (`age` > 30) FOR UPDATE"
+	if queryErr.SQL() != want {
+		t.Errorf("Expected %s, got %s", want, queryErr.SQL())
+	}
+}
+
+func TestUnconditionalWriteGuard(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{Name: "Ann", Email: "ann@example.com"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	empty := []gpa.Condition{
+		nil,
+		RawCondition{Query: " "},
+		gpa.CompositeCondition{Logic: gpa.LogicAnd},
+		gpa.CompositeCondition{Logic: gpa.LogicOr, Conditions: []gpa.Condition{RawCondition{}}},
+	}
+	for _, condition := range empty {
+		if err := repo.DeleteByCondition(ctx, condition); !gpa.IsValidation(err) {
+			t.Errorf("Expected validation error for %#v, got %v", condition, err)
+		}
+	}
+	if _, err := repo.DeleteAll(ctx, WhereRaw(" ")); !gpa.IsValidation(err) {
+		t.Errorf("Expected validation error for empty raw condition, got %v", err)
+	}
+
+	count, err := repo.Count(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected no rows to be deleted, got %d remaining", count)
+	}
+}
+
+type TestStamped struct {
+	bun.BaseModel `bun:"table:test_stamped"`
+
+	ID        int64     `bun:",pk,autoincrement"`
+	Name      string    `bun:"name"`
+	Slug      string    `bun:"slug"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero"`
+}
+
+var testStampTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func (s *TestStamped) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		if s.Slug == "" {
+			s.Slug = strings.ToLower(s.Name)
+		}
+		s.UpdatedAt = testStampTime
+	case *bun.UpdateQuery:
+		s.UpdatedAt = testStampTime
+	}
+	return nil
+}
+
+func TestRepositoryBeforeAppendModel(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestStamped)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestStamped](provider).(*Repository[TestStamped])
+
+	reset := func(id int64) {
+		t.Helper()
+		if _, err := provider.db.NewUpdate().Model((*TestStamped)(nil)).Set("updated_at = NULL").Where("id = ?", id).Exec(ctx); err != nil {
+			t.Fatalf("Failed to reset timestamp: %v", err)
+		}
+	}
+	check := func(path string, id int64) {
+		t.Helper()
+		found, err := repo.FindByID(ctx, id)
+		if err != nil {
+			t.Fatalf("%s: failed to find entity: %v", path, err)
+		}
+		if !found.UpdatedAt.Equal(testStampTime) {
+			t.Errorf("%s: expected the hook to stamp updated_at, got %v", path, found.UpdatedAt)
+		}
+	}
+
+	entity := &TestStamped{Name: "First"}
+	if err := repo.Create(ctx, entity); err != nil {
+		t.Fatalf("Failed to create entity: %v", err)
+	}
+	check("Create", entity.ID)
+	if found, _ := repo.FindByID(ctx, entity.ID); found == nil || found.Slug != "first" {
+		t.Errorf("Expected the hook to set the slug, got %+v", found)
+	}
+
+	batch := []*TestStamped{{Name: "A"}, {Name: "B"}}
+	if err := repo.CreateBatch(ctx, batch); err != nil {
+		t.Fatalf("Failed to create batch: %v", err)
+	}
+	check("CreateBatch", batch[1].ID)
+
+	reset(entity.ID)
+	entity.UpdatedAt = time.Time{}
+	if err := repo.Update(ctx, entity); err != nil {
+		t.Fatalf("Failed to update entity: %v", err)
+	}
+	check("Update", entity.ID)
+
+	reset(entity.ID)
+	if err := repo.UpdatePartial(ctx, entity.ID, map[string]interface{}{"name": "Renamed"}); err != nil {
+		t.Fatalf("Failed to update partially: %v", err)
+	}
+	check("UpdatePartial", entity.ID)
+
+	reset(entity.ID)
+	if err := repo.UpdateNonZero(ctx, &TestStamped{ID: entity.ID, Name: "Again"}); err != nil {
+		t.Fatalf("Failed to update non-zero fields: %v", err)
+	}
+	check("UpdateNonZero", entity.ID)
+	if found, _ := repo.FindByID(ctx, entity.ID); found == nil || found.Slug != "first" {
+		t.Errorf("Expected UpdateNonZero to keep the slug, got %+v", found)
+	}
+}
+
+// appendHookCalls counts the BeforeAppendModel calls of TestCountedHook
+type appendHookCalls struct{}
+
+type TestCountedHook struct {
+	bun.BaseModel `bun:"table:test_counted_hooks"`
+
+	ID        int64     `bun:",pk,autoincrement"`
+	Name      string    `bun:"name"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero"`
+}
+
+func (h *TestCountedHook) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if calls, ok := ctx.Value(appendHookCalls{}).(*int); ok {
+		*calls++
+	}
+	h.UpdatedAt = testStampTime
+	return nil
+}
+
+func TestRepositoryBeforeAppendModelOnce(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestCountedHook)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestCountedHook](provider).(*Repository[TestCountedHook])
+	entity := &TestCountedHook{Name: "Ann"}
+	if err := repo.Create(ctx, entity); err != nil {
+		t.Fatalf("Failed to create entity: %v", err)
+	}
+
+	for name, update := range map[string]func(ctx context.Context) error{
+		"UpdateNonZero": func(ctx context.Context) error {
+			return repo.UpdateNonZero(ctx, &TestCountedHook{ID: entity.ID, Name: "Bob"})
+		},
+		"UpdatePartial": func(ctx context.Context) error {
+			return repo.UpdatePartial(ctx, entity.ID, map[string]interface{}{"name": "Cid"})
+		},
+	} {
+		calls := 0
+		if err := update(context.WithValue(ctx, appendHookCalls{}, &calls)); err != nil {
+			t.Fatalf("%s: failed to update: %v", name, err)
+		}
+		if calls != 1 {
+			t.Errorf("%s: expected the hook to run once, ran %d times", name, calls)
+		}
+	}
+}
+
+type TestToken struct {
+	bun.BaseModel `bun:"table:test_tokens"`
+
+	ID   string `bun:",pk,type:uuid"`
+	Name string `bun:"name"`
+}
+
+type TestBinaryKey struct {
+	bun.BaseModel `bun:"table:test_binary_keys"`
+
+	ID   [16]byte `bun:",pk"`
+	Name string   `bun:"name"`
+}
+
+func TestRepositoryUUIDKeys(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	for _, model := range []interface{}{(*TestToken)(nil), (*TestBinaryKey)(nil)} {
+		if _, err := provider.db.NewCreateTable().Model(model).Exec(ctx); err != nil {
+			t.Fatalf("Failed to create test table: %v", err)
+		}
+	}
+
+	tokens := GetRepository[TestToken](provider)
+	token := &TestToken{Name: "a"}
+	if err := tokens.Create(ctx, token); err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if len(token.ID) != 36 || token.ID[14] != '4' {
+		t.Errorf("Expected a v4 UUID, got %q", token.ID)
+	}
+	if found, err := tokens.FindByID(ctx, token.ID); err != nil || found.Name != "a" {
+		t.Errorf("Failed to find token by generated id: %v", err)
+	}
+
+	preset := &TestToken{ID: "00000000-0000-4000-8000-000000000001", Name: "preset"}
+	if err := tokens.Create(ctx, preset); err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if preset.ID != "00000000-0000-4000-8000-000000000001" {
+		t.Errorf("Expected a preset id to be kept, got %q", preset.ID)
+	}
+
+	provider.SetUUIDGenerator(UUIDv7)
+	batch := []*TestToken{{Name: "b"}, {Name: "c"}}
+	if err := tokens.CreateBatch(ctx, batch); err != nil {
+		t.Fatalf("Failed to create tokens: %v", err)
+	}
+	if batch[0].ID[14] != '7' || batch[0].ID == batch[1].ID {
+		t.Errorf("Expected distinct v7 UUIDs, got %q and %q", batch[0].ID, batch[1].ID)
+	}
+
+	keys := GetRepository[TestBinaryKey](provider)
+	key := &TestBinaryKey{Name: "bin"}
+	if err := keys.Create(ctx, key); err != nil {
+		t.Fatalf("Failed to create binary key: %v", err)
+	}
+	if key.ID == [16]byte{} || key.ID[6]>>4 != 7 {
+		t.Errorf("Expected a generated v7 key, got %x", key.ID)
+	}
+}
+
+func TestUUIDv7Order(t *testing.T) {
+	first, err := UUIDv7()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second, err := UUIDv7()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+	if formatUUID(first) >= formatUUID(second) {
+		t.Errorf("Expected %s to sort before %s", formatUUID(first), formatUUID(second))
+	}
+	if first[8]&0xc0 != 0x80 {
+		t.Errorf("Expected the RFC 4122 variant, got %x", first[8])
+	}
+}
+
+func TestRawQueryPlaceholders(t *testing.T) {
+	ctx := context.Background()
+	want := "SELECT * FROM missing WHERE name = 'o''neil' AND age > 3 AND note = '?'"
+	for _, d := range []schema.Dialect{pgdialect.New(), mysqldialect.New(), sqlitedialect.New()} {
+		repo := newDialectRepository(t, d)
+		_, err := repo.RawQuery(ctx, `SELECT * FROM missing WHERE name = ? AND age > ? AND note = '\?'`, []interface{}{"o'neil", 3})
+
+		var queryErr *QueryError
+		if !errors.As(err, &queryErr) {
+			t.Fatalf("%s: expected a QueryError, got %v", d.Name(), err)
+		}
+		if queryErr.SQL() != want {
+			t.Errorf("%s: expected %s, got %s", d.Name(), want, queryErr.SQL())
+		}
+	}
+}
+
+func TestRepositoryFindAndDeleteByIDs(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	size := maxBindParams(repo.db.Dialect().Name())
+	users := make([]*TestUser, size+500)
+	for i := range users {
+		users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Age: i % 2}
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	ids := make([]interface{}, 0, len(users)+1)
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	ids = append(ids, int64(-1))
+
+	found, err := repo.FindByIDs(ctx, ids)
+	if err != nil {
+		t.Fatalf("Failed to find users by ids: %v", err)
+	}
+	if len(found) != len(users) {
+		t.Errorf("Expected %d users, got %d", len(users), len(found))
+	}
+
+	if found, err := repo.FindByIDs(ctx, nil); err != nil || len(found) != 0 {
+		t.Errorf("Expected no users for no ids, got %d, %v", len(found), err)
+	}
+
+	scoped := GetScopedRepository[TestUser](repo.provider, gpa.Where("age", gpa.OpEqual, 1)).(*Repository[TestUser])
+	deleted, err := scoped.DeleteByIDs(ctx, ids)
+	if err != nil {
+		t.Fatalf("Failed to delete users by ids: %v", err)
+	}
+	if deleted != int64(len(users)/2) {
+		t.Errorf("Expected %d deleted users, got %d", len(users)/2, deleted)
+	}
+
+	deleted, err = repo.DeleteByIDs(ctx, ids)
+	if err != nil {
+		t.Fatalf("Failed to delete users by ids: %v", err)
+	}
+	if deleted != int64(len(users)-len(users)/2) {
+		t.Errorf("Expected %d deleted users, got %d", len(users)-len(users)/2, deleted)
+	}
+}
+
+type TestBaseModel struct {
+	ID        int64     `bun:",pk,autoincrement"`
+	CreatedAt time.Time `bun:"created_at,nullzero"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero"`
+}
+
+type TestEntry struct {
+	bun.BaseModel `bun:"table:test_entries"`
+	TestBaseModel
+
+	Title string `bun:"title"`
+	Views int    `bun:"views"`
+}
+
+func TestRepositoryEmbeddedStruct(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestEntry)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestEntry](provider).(*Repository[TestEntry])
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := &TestEntry{TestBaseModel: TestBaseModel{CreatedAt: created}, Title: "Hello"}
+	if err := repo.Create(ctx, entry); err != nil {
+		t.Fatalf("Failed to create entry: %v", err)
+	}
+	if entry.ID == 0 {
+		t.Fatal("Expected the embedded primary key to be set")
+	}
+
+	updated := created.Add(time.Hour)
+	if err := repo.UpdatePartial(ctx, entry.ID, map[string]interface{}{"updated_at": updated}); err != nil {
+		t.Fatalf("Failed to update partially: %v", err)
+	}
+	if err := repo.UpdateNonZero(ctx, &TestEntry{TestBaseModel: TestBaseModel{ID: entry.ID}, Views: 3}); err != nil {
+		t.Fatalf("Failed to update non-zero fields: %v", err)
+	}
+
+	found, err := repo.FindByID(ctx, entry.ID)
+	if err != nil {
+		t.Fatalf("Failed to find entry: %v", err)
+	}
+	if !found.CreatedAt.Equal(created) || !found.UpdatedAt.Equal(updated) || found.Title != "Hello" || found.Views != 3 {
+		t.Errorf("Unexpected entry: %+v", found)
+	}
+
+	info, err := repo.GetEntityInfo()
+	if err != nil {
+		t.Fatalf("Failed to get entity info: %v", err)
+	}
+	var names []string
+	for _, field := range info.Fields {
+		names = append(names, field.Name)
+	}
+	if strings.Join(names, ",") != "id,created_at,updated_at,title,views" {
+		t.Errorf("Expected flattened fields, got %v", names)
+	}
+	if len(info.PrimaryKey) != 1 || info.PrimaryKey[0] != "id" || !info.Fields[0].IsPrimaryKey || !info.Fields[0].IsAutoIncrement {
+		t.Errorf("Expected id as the primary key, got %v", info.PrimaryKey)
+	}
+}
+
+func TestRepositoryDefaultOrder(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{
+		Driver:   "sqlite3",
+		Database: ":memory:",
+		Options: map[string]interface{}{
+			"bun": map[string]interface{}{"order_by_primary_key": true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	recorder := &recordingQueryHook{}
+	provider.AddQueryHook(recorder)
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestUser)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestUser](provider).(*Repository[TestUser])
+	if err := repo.CreateBatch(ctx, []*TestUser{
+		{Name: "Bob", Email: "bob@example.com", Age: 40},
+		{Name: "Ann", Email: "ann@example.com", Age: 30},
+	}); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	names := func(users []*TestUser) string {
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	recorder.queries = nil
+	users, err := repo.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if !strings.HasSuffix(recorder.queries[0], `ORDER BY "test_user"."id" ASC`) {
+		t.Errorf("Expected ordering by primary key, got %s", recorder.queries[0])
+	}
+	if names(users) != "Bob,Ann" {
+		t.Errorf("Unexpected order: %s", names(users))
+	}
+
+	byName := repo.OrderedBy(gpa.Order{Field: "name", Direction: gpa.OrderAsc})
+	if users, err = byName.FindAll(ctx); err != nil || names(users) != "Ann,Bob" {
+		t.Errorf("Expected repository order by name, got %s, %v", names(users), err)
+	}
+
+	if users, err = byName.FindAll(ctx, gpa.OrderBy("age", gpa.OrderDesc)); err != nil || names(users) != "Bob,Ann" {
+		t.Errorf("Expected explicit order to win, got %s, %v", names(users), err)
+	}
+}
+
+type TestTicket struct {
+	bun.BaseModel `bun:"table:test_tickets"`
+
+	ID       int64  `bun:",pk,autoincrement"`
+	Status   string `bun:"status,nullzero"`
+	Priority int    `bun:"priority"`
+}
+
+func TestRepositoryCountBy(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestTicket)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestTicket](provider).(*Repository[TestTicket])
+	if err := repo.CreateBatch(ctx, []*TestTicket{
+		{Status: "open", Priority: 1},
+		{Status: "open", Priority: 2},
+		{Status: "closed", Priority: 1},
+		{Priority: 1},
+	}); err != nil {
+		t.Fatalf("Failed to create tickets: %v", err)
+	}
+
+	counts, err := repo.CountBy(ctx, "status", gpa.OrderBy("id", gpa.OrderAsc), gpa.Limit(1))
+	if err != nil {
+		t.Fatalf("Failed to count by status: %v", err)
+	}
+	want := map[string]int64{"open": 2, "closed": 1, NullGroup: 1}
+	if len(counts) != len(want) {
+		t.Errorf("Expected %v, got %v", want, counts)
+	}
+	for key, count := range want {
+		if counts[key] != count {
+			t.Errorf("Expected %d for %q, got %d", count, key, counts[key])
+		}
+	}
+
+	counts, err = repo.CountBy(ctx, "priority", gpa.Where("status", gpa.OpEqual, "open"))
+	if err != nil {
+		t.Fatalf("Failed to count by priority: %v", err)
+	}
+	if len(counts) != 2 || counts["1"] != 1 || counts["2"] != 1 {
+		t.Errorf("Unexpected counts by priority: %v", counts)
+	}
+}
+
+type (
+	sessionUserKey struct{}
+	sessionFailKey struct{}
+)
+
+func TestRepositorySessionVariables(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	errNoTenant := errors.New("no tenant")
+	repo.provider.SetSessionVariables(func(ctx context.Context) (map[string]string, error) {
+		if ctx.Value(sessionFailKey{}) != nil {
+			return nil, errNoTenant
+		}
+		user, ok := ctx.Value(sessionUserKey{}).(string)
+		if !ok {
+			return nil, nil
+		}
+		return map[string]string{"app.user_id": user}, nil
+	})
+
+	// Without settings the operations run as usual
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{Name: "John Doe", Email: "john@example.com", Age: 30}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if users, err := repo.FindAll(ctx); err != nil || len(users) != 1 {
+		t.Fatalf("Expected 1 user, got %d (%v)", len(users), err)
+	}
+
+	// SQLite has no session settings to apply them with
+	userCtx := context.WithValue(ctx, sessionUserKey{}, "42")
+	if _, err := repo.FindAll(userCtx); !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error from FindAll, got %v", err)
+	}
+	if err := repo.Create(userCtx, &TestUser{Name: "Jane Doe"}); !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error from Create, got %v", err)
+	}
+	err := repo.Transaction(userCtx, func(tx gpa.Transaction[TestUser]) error {
+		t.Error("Transaction ran without its session variables")
+		return nil
+	})
+	if !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error from Transaction, got %v", err)
+	}
+
+	failCtx := context.WithValue(ctx, sessionFailKey{}, true)
+	if _, err := repo.Count(failCtx); !errors.Is(err, errNoTenant) {
+		t.Errorf("Expected the session variables error, got %v", err)
+	}
+	if count, err := repo.Count(ctx); err != nil || count != 1 {
+		t.Errorf("Expected 1 user, got %d (%v)", count, err)
+	}
+}
+
+func TestRepositoryTenantSchema(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	repo.provider.SetTenantSchema(func(ctx context.Context) (string, error) {
+		tenant, _ := ctx.Value(sessionUserKey{}).(string)
+		return tenant, nil
+	})
+
+	ctx := context.Background()
+	if err := repo.Create(ctx, &TestUser{Name: "John Doe", Email: "john@example.com"}); err != nil {
+		t.Fatalf("Expected operations without a tenant to run as usual, got %v", err)
+	}
+	// SQLite has no search_path to switch
+	tenantCtx := context.WithValue(ctx, sessionUserKey{}, "tenant_xyz")
+	if _, err := repo.FindAll(tenantCtx); !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error from FindAll, got %v", err)
+	}
+
+	pg := &Provider{db: newDialectRepository(t, pgdialect.New()).db.(*bun.DB)}
+	pg.SetSessionVariables(func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{"app.user_id": "42"}, nil
+	})
+	pg.SetTenantSchema(repo.provider.tenantSchema)
+	settings, err := pg.sessionSettings(tenantCtx)
+	if err != nil {
+		t.Fatalf("Failed to resolve settings: %v", err)
+	}
+	if len(settings) != 2 || settings["app.user_id"] != "42" || settings["search_path"] != `"tenant_xyz", public` {
+		t.Errorf("Unexpected settings: %v", settings)
+	}
+	if settings, _ := pg.sessionSettings(ctx); len(settings) != 1 {
+		t.Errorf("Expected only the session variables without a tenant, got %v", settings)
+	}
+}
+
+// TestMoney is stored as text such as "USD 12.34" through its Valuer and Scanner
+type TestMoney struct {
+	Currency string
+	Cents    int64
+}
+
+func (m TestMoney) Value() (driver.Value, error) {
+	return fmt.Sprintf("%s %d.%02d", m.Currency, m.Cents/100, m.Cents%100), nil
+}
+
+func (m *TestMoney) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into TestMoney", src)
+	}
+	var units, cents int64
+	if _, err := fmt.Sscanf(s, "%s %d.%d", &m.Currency, &units, &cents); err != nil {
+		return err
+	}
+	m.Cents = units*100 + cents
+	return nil
+}
+
+type TestInvoice struct {
+	ID       int64      `bun:",pk,autoincrement"`
+	Total    TestMoney  `bun:"total,type:varchar(32)"`
+	Discount *TestMoney `bun:"discount,type:varchar(32)"`
+}
+
+func TestRepositoryCustomValuerType(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestInvoice)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestInvoice](provider).(*Repository[TestInvoice])
+
+	invoice := &TestInvoice{Total: TestMoney{Currency: "USD", Cents: 1234}}
+	if err := repo.Create(ctx, invoice); err != nil {
+		t.Fatalf("Failed to create invoice: %v", err)
+	}
+
+	var stored string
+	if err := provider.db.NewRaw("SELECT total FROM test_invoices WHERE id = ?", invoice.ID).Scan(ctx, &stored); err != nil {
+		t.Fatalf("Failed to read stored total: %v", err)
+	}
+	if stored != "USD 12.34" {
+		t.Errorf("Expected the Valuer's text to be stored, got %q", stored)
+	}
+
+	found, err := repo.FindByID(ctx, invoice.ID)
+	if err != nil {
+		t.Fatalf("Failed to find invoice: %v", err)
+	}
+	if found.Total != invoice.Total || found.Discount != nil {
+		t.Errorf("Expected %+v without discount, got %+v", invoice.Total, found)
+	}
+
+	discount := TestMoney{Currency: "USD", Cents: 250}
+	if err := repo.UpdatePartial(ctx, invoice.ID, map[string]interface{}{"discount": discount}); err != nil {
+		t.Fatalf("Failed to update discount: %v", err)
+	}
+	matches, err := repo.FindByExample(ctx, &TestInvoice{Total: invoice.Total})
+	if err != nil {
+		t.Fatalf("Failed to find by example: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Discount == nil || *matches[0].Discount != discount {
+		t.Errorf("Expected the invoice with discount %+v, got %+v", discount, matches)
+	}
+
+	count, err := repo.Count(ctx, gpa.Where("total", gpa.OpEqual, TestMoney{Currency: "USD", Cents: 1234}))
+	if err != nil || count != 1 {
+		t.Errorf("Expected to match the total by value, got %d (%v)", count, err)
+	}
+}
+
+type TestImportRow struct {
+	ID        int64             `bun:",pk,autoincrement"`
+	Name      string            `bun:"name"`
+	Tags      []string          `bun:"tags,array"`
+	Meta      map[string]string `bun:"meta,type:jsonb"`
+	Total     TestMoney         `bun:"total,type:varchar(32)"`
+	Note      *string           `bun:"note"`
+	DeletedAt time.Time         `bun:"deleted_at,nullzero"`
+}
+
+func TestRepositoryBulkCopy(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	_, err := repo.BulkCopy(context.Background(), []*TestUser{{Name: "John Doe"}})
+	if !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error on sqlite, got %v", err)
+	}
+
+	table := pgdialect.New().Tables().Get(reflect.TypeOf((*TestImportRow)(nil)).Elem())
+	var fields []*schema.Field
+	for _, field := range table.Fields {
+		if !field.AutoIncrement {
+			fields = append(fields, field)
+		}
+	}
+	row := &TestImportRow{
+		Name:  "widget",
+		Tags:  []string{"a", "b"},
+		Meta:  map[string]string{"source": "csv"},
+		Total: TestMoney{Currency: "USD", Cents: 500},
+	}
+	values, err := copyValues(fields, reflect.ValueOf(row).Elem())
+	if err != nil {
+		t.Fatalf("Failed to get copy values: %v", err)
+	}
+	if len(values) != 6 || values[0] != "widget" {
+		t.Fatalf("Unexpected copy values: %v", values)
+	}
+	if tags, err := values[1].(driver.Valuer).Value(); err != nil || tags != `{"a","b"}` {
+		t.Errorf("Expected a postgres array, got %v (%v)", tags, err)
+	}
+	if values[2] != `{"source":"csv"}` {
+		t.Errorf("Expected JSON, got %v", values[2])
+	}
+	if values[3] != row.Total {
+		t.Errorf("Expected the Valuer to pass through, got %v", values[3])
+	}
+	if values[4] != nil || values[5] != nil {
+		t.Errorf("Expected NULL for the nil pointer and nullzero time, got %v and %v", values[4], values[5])
+	}
+}
+
+type TestStampedRow struct {
+	bun.BaseModel `bun:"table:stamped_rows"`
+
+	ID        int64     `bun:",pk,autoincrement"`
+	Name      string    `bun:"name"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+func TestCopyFieldsDefaults(t *testing.T) {
+	table := pgdialect.New().Tables().Get(reflect.TypeOf((*TestStampedRow)(nil)).Elem())
+	names := func(fields []*schema.Field) []string {
+		var names []string
+		for _, field := range fields {
+			names = append(names, field.Name)
+		}
+		return names
+	}
+
+	// Left to its default in every row, the column is left out
+	fields, err := copyFields(table, []*TestStampedRow{{Name: "a"}, {Name: "b"}})
+	if err != nil {
+		t.Fatalf("Failed to get copy fields: %v", err)
+	}
+	if got := names(fields); len(got) != 1 || got[0] != "name" {
+		t.Errorf("Expected only name to be copied, got %v", got)
+	}
+
+	fields, err = copyFields(table, []*TestStampedRow{{Name: "a", CreatedAt: time.Now()}})
+	if err != nil {
+		t.Fatalf("Failed to get copy fields: %v", err)
+	}
+	if got := names(fields); len(got) != 2 {
+		t.Errorf("Expected name and created_at to be copied, got %v", got)
+	}
+
+	_, err = copyFields(table, []*TestStampedRow{{Name: "a", CreatedAt: time.Now()}, {Name: "b"}})
+	if !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for a default in only some rows, got %v", err)
+	}
+}
+
+func TestRepositoryPackInserts(t *testing.T) {
+	repo := newDialectRepository(t, mysqldialect.New())
+
+	users := make([]*TestUser, 100)
+	for i := range users {
+		users[i] = &TestUser{Name: fmt.Sprintf("user%03d", i), Email: fmt.Sprintf("user%03d@example.com", i), Age: i}
+	}
+
+	var statements []string
+	limit := 1000
+	err := repo.packInserts(repo.db, users, limit, func(query string) error {
+		statements = append(statements, query)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to pack inserts: %v", err)
+	}
+	if len(statements) < 2 {
+		t.Fatalf("Expected the rows to be split into several statements, got %d", len(statements))
+	}
+
+	rows := 0
+	for _, query := range statements {
+		if len(query) > limit {
+			t.Errorf("Statement of %d bytes exceeds the limit: %s", len(query), query)
+		}
+		if !strings.HasPrefix(query, "INSERT INTO `test_users`") {
+			t.Errorf("Unexpected statement: %s", query)
+		}
+		rows += strings.Count(query, "@example.com'")
+	}
+	if rows != len(users) {
+		t.Errorf("Expected %d rows across the statements, got %d", len(users), rows)
+	}
+
+	// A row larger than the limit is still sent on its own
+	statements = nil
+	if err := repo.packInserts(repo.db, users[:3], 10, func(query string) error {
+		statements = append(statements, query)
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to pack inserts: %v", err)
+	}
+	if len(statements) != 3 {
+		t.Errorf("Expected one statement per row, got %d", len(statements))
+	}
+}
+
+// BenchmarkInsert compares inserting rows one statement at a time with the
+// multi-row statements of CreateBatch and FastInsert
+func BenchmarkInsert(b *testing.B) {
+	const rows = 1000
+	newUsers := func() []*TestUser {
+		users := make([]*TestUser, rows)
+		for i := range users {
+			users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Age: i % 90}
+		}
+		return users
+	}
+
+	b.Run("Create", func(b *testing.B) {
+		repo, cleanup := setupTestRepository(b)
+		defer cleanup()
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			for _, user := range newUsers() {
+				if err := repo.Create(ctx, user); err != nil {
+					b.Fatalf("Failed to create user: %v", err)
+				}
+			}
+		}
+	})
+
+	b.Run("CreateBatch", func(b *testing.B) {
+		repo, cleanup := setupTestRepository(b)
+		defer cleanup()
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			if err := repo.CreateBatch(ctx, newUsers()); err != nil {
+				b.Fatalf("Failed to create users: %v", err)
+			}
+		}
+	})
+
+	b.Run("FastInsert", func(b *testing.B) {
+		repo, cleanup := setupTestRepository(b)
+		defer cleanup()
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			if _, err := repo.FastInsert(ctx, newUsers()); err != nil {
+				b.Fatalf("Failed to insert users: %v", err)
+			}
+		}
+	})
+}
+
+func TestRepositoryFastInsert(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := make([]*TestUser, 2*repo.BatchSize()+1)
+	for i := range users {
+		users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Age: i % 90}
+	}
+	// Values are inlined, so quotes and placeholders must be escaped
+	users[0].Name = "O'Brien ?"
+	n, err := repo.FastInsert(ctx, users)
+	if err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	if n != int64(len(users)) {
+		t.Errorf("Expected %d rows inserted, got %d", len(users), n)
+	}
+	if count, err := repo.Count(ctx); err != nil || count != int64(len(users)) {
+		t.Errorf("Expected %d users, got %d, %v", len(users), count, err)
+	}
+	if users[0].ID != 0 {
+		t.Errorf("Expected generated ids not to be read back, got %d", users[0].ID)
+	}
+	found, err := repo.FindByID(ctx, 1)
+	if err != nil || found.Name != "O'Brien ?" || found.Email != "user0@example.com" {
+		t.Errorf("Expected the first user as given, got %v, %v", found, err)
+	}
+
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestContact)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	contacts := GetRepository[TestContact](repo.provider).(*Repository[TestContact])
+	if _, err := contacts.FastInsert(ctx, []*TestContact{{Nickname: "ann"}, {}}); err != nil {
+		t.Fatalf("Failed to insert contacts: %v", err)
+	}
+	if count, err := contacts.Count(ctx, gpa.WhereNull("nickname")); err != nil || count != 1 {
+		t.Errorf("Expected the zero nullzero nickname inserted as NULL, got %d, %v", count, err)
+	}
+
+	// The bare connection has no test_users table; the error carries the SQL
+	_, err = newDialectRepository(t, pgdialect.New()).FastInsert(ctx, []*TestUser{{Name: "Ann"}})
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || !strings.HasPrefix(queryErr.SQL(), `INSERT INTO "test_users"`) {
+		t.Errorf("Expected a QueryError carrying the INSERT, got %v", err)
+	}
+}
+
+func TestRepositoryPaginateWindowed(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		user := &TestUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Age: 20 + i}
+		if err := repo.Create(ctx, user); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	page, total, err := repo.PaginateWindowed(ctx,
+		gpa.Where("age", gpa.OpGreaterThan, 20),
+		gpa.OrderBy("age", gpa.OrderAsc),
+		gpa.Limit(2), gpa.Offset(1),
+	)
+	if err != nil {
+		t.Fatalf("Failed to paginate: %v", err)
+	}
+	if total != 4 {
+		t.Errorf("Expected a total of 4, got %d", total)
+	}
+	if len(page) != 2 || page[0].Entity.Name != "user2" || page[1].Entity.Name != "user3" {
+		t.Fatalf("Expected user2 and user3, got %+v", page)
+	}
+	for _, row := range page {
+		if row.TotalCount != 4 || row.Entity.ID == 0 || row.Entity.Email == "" {
+			t.Errorf("Expected a fully scanned row with the total, got %+v", row)
+		}
+	}
+
+	page, total, err = repo.PaginateWindowed(ctx, gpa.Limit(2), gpa.Offset(10))
+	if err != nil {
+		t.Fatalf("Failed to paginate past the end: %v", err)
+	}
+	if len(page) != 0 || total != 0 {
+		t.Errorf("Expected an empty page, got %d rows and total %d", len(page), total)
+	}
+}
+
+func TestRepositoryErrorsUnwrapToDriverErrors(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.FindByID(ctx, 404); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected FindByID to wrap sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.QueryOne(ctx); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected QueryOne to wrap sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.UpdateReturning(ctx, &TestUser{ID: 404, Name: "Nobody"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected UpdateReturning to wrap sql.ErrNoRows, got %v", err)
+	}
+
+	user := &TestUser{ID: 1, Name: "John Doe"}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	err := repo.Create(ctx, &TestUser{ID: 1, Name: "Jane Doe"})
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrConstraint {
+		t.Errorf("Expected the sqlite constraint error, got %v", err)
+	}
+	if !gpa.IsErrorType(err, gpa.ErrorTypeDuplicate) {
+		t.Errorf("Expected a duplicate error, got %v", err)
+	}
+
+	err = repo.provider.convertError(&pq.Error{Code: "23503", Message: "violates foreign key constraint"})
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23503" {
+		t.Errorf("Expected the pq error, got %v", err)
+	}
+	err = repo.provider.convertError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		t.Errorf("Expected the mysql error, got %v", err)
+	}
+}
+
+type TestEvent struct {
+	ID      int64  `bun:",pk"`
+	Payload string `bun:"payload"`
+	Version int64  `bun:"version"`
+}
+
+func TestRepositoryUpsert(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestEvent)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	repo := GetRepository[TestEvent](provider).(*Repository[TestEvent])
+
+	written, err := repo.Upsert(ctx, &TestEvent{ID: 1, Payload: "v2", Version: 2}, nil, UpsertIfNewer("version"))
+	if err != nil || !written {
+		t.Fatalf("Expected the insert to be written, got %v (%v)", written, err)
+	}
+
+	// A stale event is skipped
+	written, err = repo.Upsert(ctx, &TestEvent{ID: 1, Payload: "v1", Version: 1}, nil, UpsertIfNewer("version"))
+	if err != nil || written {
+		t.Errorf("Expected the stale update to be skipped, got %v (%v)", written, err)
+	}
+	if event, _ := repo.FindByID(ctx, 1); event == nil || event.Payload != "v2" {
+		t.Errorf("Expected the stored event to be kept, got %+v", event)
+	}
+
+	written, err = repo.Upsert(ctx, &TestEvent{ID: 1, Payload: "v3", Version: 3}, []string{"id"}, UpsertIfNewer("version"))
+	if err != nil || !written {
+		t.Errorf("Expected the newer update to be written, got %v (%v)", written, err)
+	}
+	if event, _ := repo.FindByID(ctx, 1); event == nil || event.Payload != "v3" || event.Version != 3 {
+		t.Errorf("Expected the newer event to be stored, got %+v", event)
+	}
+
+	written, err = repo.Upsert(ctx, &TestEvent{ID: 1, Payload: "other", Version: 3}, nil,
+		UpsertWhere("EXCLUDED.payload != ?TableAlias.payload AND EXCLUDED.version >= ?", 4))
+	if err != nil || written {
+		t.Errorf("Expected UpsertWhere to skip the update, got %v (%v)", written, err)
+	}
+
+	// Without a condition the update always applies
+	written, err = repo.Upsert(ctx, &TestEvent{ID: 1, Payload: "forced", Version: 0}, nil)
+	if err != nil || !written {
+		t.Errorf("Expected the unconditional update to be written, got %v (%v)", written, err)
+	}
+	if event, _ := repo.FindByID(ctx, 1); event == nil || event.Payload != "forced" {
+		t.Errorf("Expected the forced event to be stored, got %+v", event)
+	}
+}
+
+func TestRepositoryUpsertPartialIndex(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db := repo.provider.db
+	if _, err := db.NewCreateTable().Model((*TestNote)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "CREATE UNIQUE INDEX test_notes_live_title ON test_notes (title) WHERE deleted_at IS NULL"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	notes := GetRepository[TestNote](repo.provider).(*Repository[TestNote])
+
+	note := &TestNote{ID: 1, Title: "a"}
+	if _, err := notes.Upsert(ctx, note, []string{"title"}); err == nil {
+		t.Error("Expected the partial index not to match a plain conflict target")
+	}
+	if _, err := notes.Upsert(ctx, note, []string{"title"}, UpsertConflictWhere("deleted_at IS NULL")); err != nil {
+		t.Fatalf("Failed to insert note: %v", err)
+	}
+	if _, err := notes.Upsert(ctx, &TestNote{ID: 1, Title: "a"}, []string{"title"}, UpsertConflictWhere("deleted_at IS NULL")); err != nil {
+		t.Errorf("Expected the conflict on the partial index to update, got %v", err)
+	}
+	if count, _ := notes.Count(ctx); count != 1 {
+		t.Errorf("Expected 1 note, got %d", count)
+	}
+
+	if _, err := notes.Upsert(ctx, note, nil, UpsertOnConstraint("test_notes_pkey")); !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error for UpsertOnConstraint on sqlite, got %v", err)
+	}
+	_, err := newDialectRepository(t, mysqldialect.New()).Upsert(ctx, &TestUser{ID: 1}, []string{"email"}, UpsertConflictWhere("age > 0"))
+	if !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error for UpsertConflictWhere on mysql, got %v", err)
+	}
+
+	// The SQLite connection can't run it, but the error carries the SQL
+	pgRepo := newDialectRepository(t, pgdialect.New())
+	_, err = pgRepo.Upsert(ctx, &TestUser{ID: 1, Name: "John"}, nil, UpsertOnConstraint("test_users_email_key"))
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	if !strings.Contains(queryErr.SQL(), `ON CONFLICT ON CONSTRAINT "test_users_email_key" DO UPDATE SET`) {
+		t.Errorf("Unexpected SQL: %s", queryErr.SQL())
+	}
+}
+
+func TestRepositoryUpsertMySQL(t *testing.T) {
+	repo := newDialectRepository(t, mysqldialect.New())
+
+	// The SQLite connection can't run it, but the error carries the SQL
+	_, err := repo.Upsert(context.Background(), &TestUser{ID: 1, Name: "John", Age: 30}, nil, UpsertIfNewer("age"))
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	want := "INSERT INTO `test_users` (`id`, `name`, `email`, `age`) VALUES (1, 'John', '', 30) ON DUPLICATE KEY UPDATE " +
+		"`name` = IF(`age` IS NULL OR VALUES(`age`) > `age`, VALUES(`name`), `name`), " +
+		"`email` = IF(`age` IS NULL OR VALUES(`age`) > `age`, VALUES(`email`), `email`), " +
+		"`age` = IF(`age` IS NULL OR VALUES(`age`) > `age`, VALUES(`age`), `age`)"
+	if queryErr.SQL() != want {
+		t.Errorf("Expected %s, got %s", want, queryErr.SQL())
+	}
+
+	_, err = repo.Upsert(context.Background(), &TestUser{ID: 1}, nil, UpsertWhere("EXCLUDED.age > ?TableAlias.age"))
+	if !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error for UpsertWhere on mysql, got %v", err)
+	}
+}
+
+func TestRepositoryFindInBatches(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := make([]*TestUser, 10)
+	for i := range users {
+		users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Age: i}
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	// The order and limit don't apply; batches follow the primary key
+	var batches [][]int
+	err := repo.FindInBatches(ctx, 3, func(batch []*TestUser) error {
+		var ages []int
+		for _, user := range batch {
+			ages = append(ages, user.Age)
+		}
+		batches = append(batches, ages)
+		return nil
+	}, gpa.Where("age", gpa.OpGreaterThanOrEqual, 2), gpa.OrderBy("age", gpa.OrderDesc), gpa.Limit(1))
+	if err != nil {
+		t.Fatalf("Failed to find in batches: %v", err)
+	}
+	if fmt.Sprint(batches) != "[[2 3 4] [5 6 7] [8 9]]" {
+		t.Errorf("Unexpected batches: %v", batches)
+	}
+
+	// Rows deleted by the callback don't make later batches skip rows
+	var seen int
+	err = repo.FindInBatches(ctx, 5, func(batch []*TestUser) error {
+		seen += len(batch)
+		_, err := repo.DeleteByIDs(ctx, []interface{}{batch[0].ID})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to find in batches: %v", err)
+	}
+	if seen != 10 {
+		t.Errorf("Expected 10 users across batches, got %d", seen)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = repo.FindInBatches(ctx, 2, func(batch []*TestUser) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Expected the callback error after one batch, got %v after %d", err, calls)
+	}
+
+	if err := repo.FindInBatches(ctx, 0, func([]*TestUser) error { return nil }); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for a zero batch size, got %v", err)
+	}
+}
+
+func TestRepositoryFindInBatchesCompositeKey(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+
+	ctx := context.Background()
+	if _, err := provider.db.NewCreateTable().Model((*TestUserRole)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	repo := &Repository[TestUserRole]{db: provider.db, provider: provider}
+	roles := []*TestUserRole{
+		{UserID: 2, RoleID: 1}, {UserID: 1, RoleID: 2}, {UserID: 1, RoleID: 1},
+		{UserID: 2, RoleID: 2}, {UserID: 1, RoleID: 3},
+	}
+	if err := repo.CreateBatch(ctx, roles); err != nil {
+		t.Fatalf("Failed to create roles: %v", err)
+	}
+
+	var keys []string
+	err = repo.FindInBatches(ctx, 2, func(batch []*TestUserRole) error {
+		for _, role := range batch {
+			keys = append(keys, fmt.Sprintf("%d/%d", role.UserID, role.RoleID))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to find in batches: %v", err)
+	}
+	if strings.Join(keys, " ") != "1/1 1/2 1/3 2/1 2/2" {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+}
+
+func TestDeleteByConditionOperators(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := make([]*TestUser, 10)
+	for i := range users {
+		users[i] = &TestUser{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Age: i}
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	remaining := func() string {
+		t.Helper()
+		found, err := repo.FindAll(ctx, gpa.OrderBy("age", gpa.OrderAsc))
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+		var ages []int
+		for _, user := range found {
+			ages = append(ages, user.Age)
+		}
+		return fmt.Sprint(ages)
+	}
+
+	steps := []struct {
+		condition gpa.Condition
+		want      string
+	}{
+		{gpa.WhereCondition("age", gpa.OpIn, []interface{}{1, 3}), "[0 2 4 5 6 7 8 9]"},
+		{gpa.WhereCondition("age", gpa.OpBetween, []int{4, 6}), "[0 2 7 8 9]"},
+		{gpa.CompositeCondition{
+			Logic: gpa.LogicOr,
+			Conditions: []gpa.Condition{
+				gpa.WhereCondition("age", gpa.OpEqual, 0),
+				gpa.WhereCondition("age", gpa.OpNotBetween, [2]int{2, 8}),
+			},
+		}, "[2 7 8]"},
+		{gpa.WhereCondition("email", gpa.OpIsNull, nil), "[2 7 8]"},
+	}
+	for _, step := range steps {
+		if err := repo.DeleteByCondition(ctx, step.condition); err != nil {
+			t.Fatalf("Failed to delete by %v: %v", step.condition, err)
+		}
+		if got := remaining(); got != step.want {
+			t.Errorf("After deleting by %v expected ages %s, got %s", step.condition, step.want, got)
+		}
+	}
+
+	for _, condition := range []gpa.Condition{
+		gpa.WhereCondition("age", gpa.OpBetween, []int{1, 2, 3}),
+		gpa.WhereCondition("age", gpa.OpIn, 2),
+	} {
+		if err := repo.DeleteByCondition(ctx, condition); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+			t.Errorf("Expected invalid argument for %v, got %v", condition, err)
+		}
+	}
+}
+
+func TestSelectAsArgs(t *testing.T) {
+	repo := newDialectRepository(t, sqlitedialect.New())
+
+	args := make([]interface{}, 1, 2)
+	args[0] = 1
+	first := repo.Build(SelectAs("age + ?", "next_age", args...), SelectAs("age - ?", "prev_age", args...)).SQL()
+	if len(args) != 1 || cap(args) != 2 {
+		t.Fatalf("Expected the caller's args untouched, got %v", args)
+	}
+	if extra := args[:2][1]; extra != nil {
+		t.Errorf("Expected nothing written past the caller's args, got %v", extra)
+	}
+	if !strings.Contains(first, `age + 1 AS "next_age"`) || !strings.Contains(first, `age - 1 AS "prev_age"`) {
+		t.Errorf("Expected both aliases, got %s", first)
+	}
+}
+
+func TestMalformedConditions(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	badBetween := gpa.WhereCondition("age", gpa.OpBetween, 5)
+	badLogic := gpa.CompositeCondition{
+		Logic:      "OR 1 = 1 OR",
+		Conditions: []gpa.Condition{gpa.WhereCondition("age", gpa.OpEqual, 1), gpa.WhereCondition("age", gpa.OpEqual, 2)},
+	}
+
+	if _, err := repo.FindAll(ctx, gpa.ConditionOption{Condition: badBetween}); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for a malformed BETWEEN in FindAll, got %v", err)
+	}
+	if _, err := repo.Count(ctx, gpa.ConditionOption{Condition: badLogic}); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for unknown group logic in Count, got %v", err)
+	}
+	if _, err := repo.DeleteAll(ctx, gpa.ConditionOption{Condition: badLogic}); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for unknown group logic in DeleteAll, got %v", err)
+	}
+	scoped := GetScopedRepository[TestUser](repo.provider, gpa.ConditionOption{Condition: badBetween}).(*Repository[TestUser])
+	if _, err := scoped.FindAll(ctx); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for a malformed BETWEEN scope, got %v", err)
+	}
+}
+
+type TestLegacyNote struct {
+	bun.BaseModel `bun:"table:test_legacy_notes"`
+
+	ID        int64      `bun:",pk,autoincrement"`
+	Title     string     `bun:"title"`
+	RemovedAt *time.Time `bun:"removed_at"`
+}
+
+type TestFlaggedNote struct {
+	bun.BaseModel `bun:"table:test_flagged_notes"`
+
+	ID      int64        `bun:",pk,autoincrement"`
+	Title   string       `bun:"title"`
+	Deleted sql.NullTime `bun:"deleted"`
+}
+
+func TestRegisterSoftDelete(t *testing.T) {
+	provider, err := NewProvider(gpa.Config{Driver: "sqlite3", Database: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	defer provider.Close()
+	ctx := context.Background()
+
+	if err := RegisterSoftDelete[TestLegacyNote](provider, "removed_at"); err != nil {
+		t.Fatalf("Failed to register soft delete: %v", err)
+	}
+	if err := RegisterSoftDelete[TestFlaggedNote](provider, "deleted"); err != nil {
+		t.Fatalf("Failed to register soft delete: %v", err)
+	}
+
+	legacy := GetRepository[TestLegacyNote](provider).(*Repository[TestLegacyNote])
+	if _, err := provider.db.NewCreateTable().Model((*TestLegacyNote)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := legacy.CreateBatch(ctx, []*TestLegacyNote{{Title: "a"}, {Title: "b"}}); err != nil {
+		t.Fatalf("Failed to create notes: %v", err)
+	}
+	if err := legacy.Delete(ctx, 1); err != nil {
+		t.Fatalf("Failed to delete note: %v", err)
+	}
+	if notes, err := legacy.FindAll(ctx); err != nil || len(notes) != 1 || notes[0].Title != "b" {
+		t.Errorf("Expected only the undeleted note, got %v, %v", notes, err)
+	}
+	var removedAt *time.Time
+	if err := provider.db.NewRaw("SELECT removed_at FROM test_legacy_notes WHERE id = 1").Scan(ctx, &removedAt); err != nil || removedAt == nil {
+		t.Errorf("Expected removed_at to be set, got %v, %v", removedAt, err)
+	}
+
+	flagged := GetRepository[TestFlaggedNote](provider).(*Repository[TestFlaggedNote])
+	if _, err := provider.db.NewCreateTable().Model((*TestFlaggedNote)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := flagged.CreateBatch(ctx, []*TestFlaggedNote{{Title: "a"}, {Title: "b"}}); err != nil {
+		t.Fatalf("Failed to create notes: %v", err)
+	}
+	if _, err := flagged.DeleteAll(ctx, gpa.Where("title", gpa.OpEqual, "b")); err != nil {
+		t.Fatalf("Failed to delete notes: %v", err)
+	}
+	if count, err := flagged.Count(ctx); err != nil || count != 1 {
+		t.Errorf("Expected 1 undeleted note, got %d, %v", count, err)
+	}
+	total, err := provider.db.NewSelect().Model((*TestFlaggedNote)(nil)).WhereAllWithDeleted().Count(ctx)
+	if err != nil || total != 2 {
+		t.Errorf("Expected the note to be kept, got %d rows, %v", total, err)
+	}
+
+	if err := RegisterSoftDelete[TestLegacyNote](provider, "missing"); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for an unknown column, got %v", err)
+	}
+	if err := RegisterSoftDelete[TestLegacyNote](provider, "title"); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for a text column, got %v", err)
+	}
+}
+
+func TestRepositoryRawExecReturning(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	result, err := repo.RawExecReturning(ctx, "INSERT INTO test_users (name, email, age) VALUES (?, ?, ?), (?, ?, ?) RETURNING id, name",
+		[]interface{}{"Alice", "alice@example.com", 30, "Bob", "bob@example.com", 25})
+	if err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	ids := result.(*Result).InsertedIDs()
+	if fmt.Sprint(ids) != "[1 2]" {
+		t.Errorf("Expected ids [1 2], got %v", ids)
+	}
+	if id, err := result.LastInsertId(); err != nil || id != 2 {
+		t.Errorf("Expected last insert id 2, got %d, %v", id, err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 2 {
+		t.Errorf("Expected 2 rows affected, got %d, %v", n, err)
+	}
+
+	if _, err := repo.RawExecReturning(ctx, "UPDATE test_users SET age = 1", nil); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument without RETURNING, got %v", err)
+	}
+
+	mysqlRepo := newDialectRepository(t, mysqldialect.New())
+	if _, err := mysqlRepo.RawExecReturning(ctx, "INSERT INTO test_users (name) VALUES ('x') RETURNING id", nil); !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error on mysql, got %v", err)
+	}
+
+	// lib/pq reports no LastInsertId for plain statements
+	pgResult := &Result{result: driver.RowsAffected(1), dialect: pgdialect.New().Name()}
+	if _, err := pgResult.LastInsertId(); !gpa.IsErrorType(err, gpa.ErrorTypeUnsupported) {
+		t.Errorf("Expected unsupported error from LastInsertId on postgres, got %v", err)
+	}
+	if n, err := pgResult.RowsAffected(); err != nil || n != 1 {
+		t.Errorf("Expected 1 row affected, got %d, %v", n, err)
+	}
+}
+
+func TestRepositoryUpsertConflictExpr(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.provider.db.ExecContext(ctx, "CREATE UNIQUE INDEX test_users_lower_email ON test_users (lower(email))"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if err := repo.Create(ctx, &TestUser{Name: "Ann", Email: "Ann@Example.com"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	if _, err := repo.Upsert(ctx, &TestUser{Name: "Annie", Email: "ann@example.com"}, []string{"email"}); err == nil {
+		t.Error("Expected the expression index not to match a column conflict target")
+	}
+	if _, err := repo.Upsert(ctx, &TestUser{Name: "Annie", Email: "ann@example.com"}, nil, UpsertConflictExpr("lower(email)")); err != nil {
+		t.Fatalf("Failed to upsert on the expression index: %v", err)
+	}
+	users, err := repo.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to find users: %v", err)
+	}
+	if len(users) != 1 || users[0].Name != "Annie" {
+		t.Errorf("Expected the existing user to be updated, got %+v", users)
+	}
+
+	// The SQLite connection can't run it, but the error carries the SQL
+	pgRepo := newDialectRepository(t, pgdialect.New())
+	_, err = pgRepo.Upsert(ctx, &TestUser{ID: 1, Email: "ann@example.com"}, nil,
+		UpsertConflictExpr("lower(email)"), UpsertConflictWhere("age > ?", 0))
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("Expected QueryError, got %v", err)
+	}
+	if !strings.Contains(queryErr.SQL(), `ON CONFLICT ((lower(email))) WHERE age > 0 DO UPDATE SET`) {
+		t.Errorf("Unexpected SQL: %s", queryErr.SQL())
+	}
+}
+
+type TestPayment struct {
+	bun.BaseModel `bun:"table:test_payments"`
+
+	ID        int64     `bun:",pk,autoincrement"`
+	Amount    float64   `bun:"amount"`
+	CreatedAt time.Time `bun:"created_at"`
+}
+
+func TestRepositoryGroupByTime(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestPayment)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	payments := GetRepository[TestPayment](repo.provider).(*Repository[TestPayment])
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, time.March, day, hour, 30, 0, 0, time.UTC)
+	}
+	// March 4th, 2024 is a Monday
+	if err := payments.CreateBatch(ctx, []*TestPayment{
+		{Amount: 10, CreatedAt: at(4, 9)},
+		{Amount: 5, CreatedAt: at(4, 9)},
+		{Amount: 1, CreatedAt: at(4, 17)},
+		{Amount: 2, CreatedAt: at(10, 8)},
+		{Amount: 4, CreatedAt: at(11, 8)},
+	}); err != nil {
+		t.Fatalf("Failed to create payments: %v", err)
+	}
+
+	format := func(buckets []TimeBucket) string {
+		var parts []string
+		for _, b := range buckets {
+			parts = append(parts, fmt.Sprintf("%s=%g", b.Bucket.UTC().Format("01-02 15h"), b.Value))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	for _, tt := range []struct {
+		interval, aggregate string
+		opts                []gpa.QueryOption
+		want                string
+	}{
+		{"day", "COUNT(*)", nil, "03-04 00h=3 03-10 00h=1 03-11 00h=1"},
+		{"hour", "SUM(amount)", []gpa.QueryOption{gpa.Where("amount", gpa.OpGreaterThan, 1)}, "03-04 09h=15 03-10 08h=2 03-11 08h=4"},
+		{"week", "SUM(amount)", nil, "03-04 00h=18 03-11 00h=4"},
+		{"Month", "MAX(amount)", nil, "03-01 00h=10"},
+	} {
+		buckets, err := payments.GroupByTime(ctx, "created_at", tt.interval, tt.aggregate, tt.opts...)
+		if err != nil {
+			t.Fatalf("Failed to group by %s: %v", tt.interval, err)
+		}
+		if got := format(buckets); got != tt.want {
+			t.Errorf("Expected %s buckets %s, got %s", tt.interval, tt.want, got)
+		}
+	}
+
+	if _, err := payments.GroupByTime(ctx, "created_at", "fortnight", "COUNT(*)"); !gpa.IsErrorType(err, gpa.ErrorTypeInvalidArgument) {
+		t.Errorf("Expected invalid argument for an unknown interval, got %v", err)
+	}
+
+	// The SQLite connection can't run them, but the errors carry the SQL
+	for _, tt := range []struct {
+		dialect schema.Dialect
+		want    string
+	}{
+		{pgdialect.New(), `SELECT date_trunc('day', "created_at") AS bucket, COUNT(*) AS value FROM "test_users" AS "test_user" GROUP BY date_trunc('day', "created_at") ORDER BY bucket ASC`},
+		{mysqldialect.New(), "SELECT CAST(DATE_FORMAT(`created_at`, '%Y-%m-%d') AS DATETIME) AS bucket, COUNT(*) AS value FROM `test_users` AS `test_user` GROUP BY CAST(DATE_FORMAT(`created_at`, '%Y-%m-%d') AS DATETIME) ORDER BY bucket ASC"},
+	} {
+		_, err := newDialectRepository(t, tt.dialect).GroupByTime(ctx, "created_at", "day", "COUNT(*)")
+		var queryErr *QueryError
+		if !errors.As(err, &queryErr) {
+			t.Fatalf("Expected QueryError, got %v", err)
+		}
+		if queryErr.SQL() != tt.want {
+			t.Errorf("Unexpected SQL: %s", queryErr.SQL())
+		}
+	}
+}
+
+func TestRepositoryConditionGroups(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "a", Email: "a@example.com", Age: 20},
+		{Name: "b", Email: "b@example.com", Age: 30},
+		{Name: "c", Email: "", Age: 30},
+		{Name: "d", Email: "d@example.com", Age: 40},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	names := func(opts ...gpa.QueryOption) string {
+		t.Helper()
+		found, err := repo.FindAll(ctx, append(opts, gpa.OrderBy("name", gpa.OrderAsc))...)
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+		var names []string
+		for _, user := range found {
+			names = append(names, user.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// (age = 20 OR age = 30) AND email != ''
+	opts := []gpa.QueryOption{
+		gpa.Or(gpa.WhereCondition("age", gpa.OpEqual, 20), gpa.WhereCondition("age", gpa.OpEqual, 30)),
+		gpa.Where("email", gpa.OpNotEqual, ""),
+	}
+	if got := names(opts...); got != "a,b" {
+		t.Errorf("Expected a,b, got %s", got)
+	}
+	if sql := repo.Build(opts...).SQL(); !strings.Contains(sql, `WHERE (("age" = 20 OR "age" = 30)) AND ("email" != '')`) {
+		t.Errorf("Expected the OR group in parentheses, got %s", sql)
+	}
+
+	// age = 40 OR (age = 30 AND email = '')
+	if got := names(gpa.Or(
+		gpa.WhereCondition("age", gpa.OpEqual, 40),
+		AllOf(gpa.WhereCondition("age", gpa.OpEqual, 30), gpa.WhereCondition("email", gpa.OpEqual, "")),
+	)); got != "c,d" {
+		t.Errorf("Expected c,d, got %s", got)
+	}
+
+	// A raw fragment keeps its own OR inside the group
+	if got := names(gpa.And(
+		RawCondition{Query: "age = ? OR age = ?", Args: []interface{}{20, 40}},
+		AnyOf(gpa.WhereCondition("name", gpa.OpEqual, "a"), gpa.WhereCondition("name", gpa.OpEqual, "b")),
+	)); got != "a" {
+		t.Errorf("Expected a, got %s", got)
+	}
+
+	// An empty group doesn't filter
+	if got := names(gpa.Or()); got != "a,b,c,d" {
+		t.Errorf("Expected all users, got %s", got)
+	}
+}
+
+func TestRepositoryNullConditions(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if _, err := repo.provider.db.NewCreateTable().Model((*TestContact)(nil)).Exec(ctx); err != nil {
+		t.Fatalf("Failed to create test table: %v", err)
+	}
+	contacts := GetRepository[TestContact](repo.provider).(*Repository[TestContact])
+	if err := contacts.CreateBatch(ctx, []*TestContact{{Nickname: "ann"}, {}, {Nickname: "bob"}, {}}); err != nil {
+		t.Fatalf("Failed to create contacts: %v", err)
+	}
+
+	count := func(opts ...gpa.QueryOption) int64 {
+		t.Helper()
+		n, err := contacts.Count(ctx, opts...)
+		if err != nil {
+			t.Fatalf("Failed to count contacts: %v", err)
+		}
+		return n
+	}
+	if n := count(gpa.WhereNull("nickname")); n != 2 {
+		t.Errorf("Expected 2 contacts without a nickname, got %d", n)
+	}
+	if n := count(gpa.WhereNotNull("nickname")); n != 2 {
+		t.Errorf("Expected 2 contacts with a nickname, got %d", n)
+	}
+	if n := count(gpa.Or(IsNull("nickname"), gpa.WhereCondition("nickname", gpa.OpEqual, "ann"))); n != 3 {
+		t.Errorf("Expected 3 contacts without a nickname or named ann, got %d", n)
+	}
+	if sql := contacts.Build(gpa.WhereNull("nickname")).SQL(); !strings.HasSuffix(sql, `WHERE ("nickname" IS NULL)`) {
+		t.Errorf("Expected IS NULL without a placeholder, got %s", sql)
+	}
+
+	if err := contacts.DeleteByCondition(ctx, IsNull("nickname")); err != nil {
+		t.Fatalf("Failed to delete contacts without a nickname: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 contacts left, got %d", n)
+	}
+	if err := contacts.DeleteByCondition(ctx, IsNotNull("nickname")); err != nil {
+		t.Fatalf("Failed to delete contacts with a nickname: %v", err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("Expected no contacts left, got %d", n)
+	}
+}
+
+func TestRepositoryBetweenConditions(t *testing.T) {
+	repo, cleanup := setupTestRepository(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	users := []*TestUser{
+		{Name: "A", Email: "a@example.com", Age: 20},
+		{Name: "B", Email: "b@example.com", Age: 30},
+		{Name: "C", Email: "c@example.com", Age: 40},
+		{Name: "D", Email: "d@example.com", Age: 50},
+	}
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+
+	count := func(opts ...gpa.QueryOption) int64 {
+		t.Helper()
+		n, err := repo.Count(ctx, opts...)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		return n
+	}
+	if n := count(WhereBetween("age", 30, 40)); n != 2 {
+		t.Errorf("Expected 2 users aged 30 to 40 inclusive, got %d", n)
+	}
+	if n := count(gpa.And(NotBetween("age", 30, 40))); n != 2 {
+		t.Errorf("Expected 2 users outside 30 to 40, got %d", n)
+	}
+	if n := count(gpa.Or(Between("age", 20, 25), Between("age", 45, 55))); n != 2 {
+		t.Errorf("Expected 2 users in either range, got %d", n)
+	}
+	if sql := repo.Build(WhereBetween("age", 30, 40)).SQL(); !strings.HasSuffix(sql, `WHERE ("age" BETWEEN 30 AND 40)`) {
+		t.Errorf("Expected both bounds bound, got %s", sql)
+	}
+
+	if err := repo.DeleteByCondition(ctx, Between("age", 20, 30)); err != nil {
+		t.Fatalf("Failed to delete users aged 20 to 30: %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 users left, got %d", n)
+	}
+}